@@ -0,0 +1,909 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Unmarshaler is the interface implemented by types that can decode
+// a bencoded representation of themselves. The input is the raw
+// encoding of a single value. UnmarshalBencode must copy the data
+// if it wishes to retain it after returning.
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+// InvalidUnmarshalError describes an invalid argument passed to Unmarshal.
+// The argument must be a non-nil pointer.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "bencode: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Pointer {
+		return "bencode: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "bencode: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// UnmarshalTypeError describes a bencoded value that was
+// not appropriate for a value of a specific Go type.
+type UnmarshalTypeError struct {
+	Value  string       // description of the bencoded value, e.g. "list" or "integer 300"
+	Type   reflect.Type // type of the Go value it could not be assigned to
+	Offset int64        // offset of the value in the input
+	Struct string       // name of the struct type containing the field
+	Field  string       // dictionary key leading to the value
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Struct != "" || e.Field != "" {
+		return fmt.Sprintf("bencode: cannot unmarshal %s into Go struct field %s.%s of type %v (offset %d)",
+			e.Value, e.Struct, e.Field, e.Type, e.Offset)
+	}
+	return fmt.Sprintf("bencode: cannot unmarshal %s into Go value of type %v (offset %d)", e.Value, e.Type, e.Offset)
+}
+
+// Reasons for strict decoding to reject the input,
+// reported as the Err of a NonCanonicalError.
+var (
+	ErrLeadingZero    = errors.New("number with leading zero")
+	ErrNegativeZero   = errors.New("negative zero integer")
+	ErrNegativeLength = errors.New("negative string length")
+	ErrUnsortedKeys   = errors.New("dictionary keys not sorted")
+	ErrDuplicateKey   = errors.New("duplicate dictionary key")
+	ErrTrailingData   = errors.New("trailing data after top-level value")
+)
+
+// NonCanonicalError describes input rejected by strict decoding
+// and the byte offset at which it was detected. Err is one of
+// the ErrLeadingZero, ErrNegativeZero, ErrNegativeLength,
+// ErrUnsortedKeys, ErrDuplicateKey or ErrTrailingData errors.
+type NonCanonicalError struct {
+	Err    error
+	Offset int64
+}
+
+func (e *NonCanonicalError) Error() string {
+	return fmt.Sprintf("bencode: non-canonical input at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *NonCanonicalError) Unwrap() error {
+	return e.Err
+}
+
+// SyntaxError describes malformed bencode input
+// and the byte offset at which it was detected.
+type SyntaxError struct {
+	msg    string
+	Offset int64
+	err    error // underlying error, e.g. io.ErrUnexpectedEOF
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("bencode: %s at offset %d", e.msg, e.Offset)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.err
+}
+
+// Unmarshal decodes the bencoded data and stores the result
+// in the value pointed to by v.
+//
+// Unmarshal uses the inverse of the mappings that Marshal uses.
+// Dictionaries are decoded into structs by matching keys against the
+// field names or "bencode" tags, preferring an exact match but also
+// accepting a case-insensitive one. Unknown keys are ignored.
+// To decode into an empty interface, Unmarshal stores one of:
+//
+//	string, for byte strings
+//	int64, for integers
+//	*big.Int, for integers overflowing int64
+//	[]any, for lists
+//	map[string]any, for dictionaries
+//
+// If a value is not appropriate for the destination type, Unmarshal
+// skips it, completes the remaining decoding and returns
+// an UnmarshalTypeError describing the first such value.
+func Unmarshal(data []byte, v any) error {
+	d := newDecodeState(data, Limits{})
+
+	return d.unmarshal(v)
+}
+
+// UnmarshalStrict is like Unmarshal, but only accepts data that is in the
+// canonical form required by the specification: integers and string lengths
+// without leading zeros or negative zero, dictionary keys unique and sorted,
+// and nothing after the top-level value. Violations are reported as a
+// *NonCanonicalError and nothing is stored in v.
+func UnmarshalStrict(data []byte, v any) error {
+	d := newDecodeState(data, Limits{})
+	d.strict = true
+
+	// Validate the whole input before decoding anything
+	if err := d.skip(); err != nil {
+		return err
+	}
+	if d.off != len(data) {
+		return d.canonicalError(ErrTrailingData, d.off)
+	}
+
+	d.off = 0
+
+	return d.unmarshal(v)
+}
+
+// decodeState holds the input and the current read offset
+// while decoding a bencoded value.
+type decodeState struct {
+	data       []byte
+	off        int
+	savedError error
+
+	// base is the offset of data in the whole input,
+	// added to the offsets reported in errors
+	base int64
+
+	limits Limits
+	depth  int
+
+	// strict rejects input that is not in canonical form
+	strict bool
+
+	// Context of the struct field being decoded, for error messages
+	errStruct string
+	errField  string
+}
+
+func newDecodeState(data []byte, limits Limits) *decodeState {
+	return &decodeState{data: data, limits: limits.withDefaults()}
+}
+
+func (d *decodeState) unmarshal(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+
+	if err := d.value(rv); err != nil {
+		return err
+	}
+
+	return d.savedError
+}
+
+// saveError saves the first err it is called with,
+// for reporting at the end of the unmarshal.
+func (d *decodeState) saveError(err error) {
+	if d.savedError == nil {
+		d.savedError = err
+	}
+}
+
+func (d *decodeState) typeError(value string, t reflect.Type, off int) {
+	d.saveError(&UnmarshalTypeError{
+		Value:  value,
+		Type:   t,
+		Offset: d.base + int64(off),
+		Struct: d.errStruct,
+		Field:  d.errField,
+	})
+}
+
+func (d *decodeState) syntaxError(off int, format string, args ...any) error {
+	return &SyntaxError{msg: fmt.Sprintf(format, args...), Offset: d.base + int64(off)}
+}
+
+func (d *decodeState) eofError() error {
+	return &SyntaxError{
+		msg:    "unexpected end of input",
+		Offset: d.base + int64(len(d.data)),
+		err:    io.ErrUnexpectedEOF,
+	}
+}
+
+func (d *decodeState) canonicalError(err error, off int) error {
+	return &NonCanonicalError{err, d.base + int64(off)}
+}
+
+// enter records that a list or dictionary starting at off is being
+// decoded, failing if that exceeds the nesting limit.
+func (d *decodeState) enter(off int) error {
+	d.depth++
+	if d.depth > d.limits.MaxDepth {
+		return d.syntaxError(off, "nesting depth exceeds limit of %d", d.limits.MaxDepth)
+	}
+
+	return nil
+}
+
+func (d *decodeState) leave() {
+	d.depth--
+}
+
+func (d *decodeState) peek() (byte, error) {
+	if d.off >= len(d.data) {
+		return 0, d.eofError()
+	}
+
+	return d.data[d.off], nil
+}
+
+// value decodes the value at the current offset into v.
+func (d *decodeState) value(v reflect.Value) error {
+	start := d.off
+
+	ch, err := d.peek()
+	if err != nil {
+		return err
+	}
+
+	u, pv := indirect(v)
+	if u != nil {
+		if err := d.skip(); err != nil {
+			return err
+		}
+
+		return u.UnmarshalBencode(d.data[start:d.off])
+	}
+	v = pv
+
+	switch {
+	case isDigit(ch) || ch == '-':
+		return d.stringValue(v)
+	case ch == 'i':
+		return d.intValue(v)
+	case ch == 'l':
+		return d.listValue(v)
+	case ch == 'd':
+		return d.dictValue(v)
+	default:
+		return d.syntaxError(d.off, "invalid value type %q", ch)
+	}
+}
+
+// indirect walks down v allocating pointers as needed,
+// until it gets to a non-pointer. If it encounters an Unmarshaler,
+// indirect stops and returns that.
+func indirect(v reflect.Value) (Unmarshaler, reflect.Value) {
+	// Start from an addressable value so that methods
+	// with pointer receivers can be found
+	if v.Kind() != reflect.Pointer && v.Type().Name() != "" && v.CanAddr() {
+		v = v.Addr()
+	}
+
+	for {
+		// Load value from interface, but only if the result will be
+		// usefully addressable
+		if v.Kind() == reflect.Interface && !v.IsNil() {
+			e := v.Elem()
+			if e.Kind() == reflect.Pointer && !e.IsNil() {
+				v = e
+				continue
+			}
+		}
+
+		if v.Kind() != reflect.Pointer {
+			break
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+
+		if v.Type().NumMethod() > 0 && v.CanInterface() {
+			if u, ok := v.Interface().(Unmarshaler); ok {
+				return u, reflect.Value{}
+			}
+		}
+
+		v = v.Elem()
+	}
+
+	return nil, v
+}
+
+// readInt reads a decimal number up to the delimiter,
+// consuming the delimiter.
+func (d *decodeState) readInt(delim byte) (string, error) {
+	start := d.off
+
+	for d.off < len(d.data) {
+		ch := d.data[d.off]
+
+		if ch == delim {
+			lit := string(d.data[start:d.off])
+			d.off++
+			return lit, nil
+		}
+		if !isDigit(ch) && !(ch == '-' && d.off == start) {
+			return "", d.syntaxError(d.off, "invalid character %q in number", ch)
+		}
+
+		d.off++
+	}
+
+	return "", d.eofError()
+}
+
+// readString reads a byte string, returning a slice of the input.
+// Example: 4:spam -> spam
+func (d *decodeState) readString() ([]byte, error) {
+	start := d.off
+
+	if ch, err := d.peek(); err != nil {
+		return nil, err
+	} else if !isDigit(ch) && ch != '-' {
+		return nil, d.syntaxError(start, "expected string, found %q", ch)
+	}
+
+	lit, err := d.readInt(':')
+	if err != nil {
+		return nil, err
+	}
+
+	if d.strict {
+		if strings.HasPrefix(lit, "-") {
+			return nil, d.canonicalError(ErrNegativeLength, start)
+		}
+		if len(lit) > 1 && lit[0] == '0' {
+			return nil, d.canonicalError(ErrLeadingZero, start)
+		}
+	}
+
+	length, err := strconv.Atoi(lit)
+	if err != nil {
+		return nil, d.syntaxError(start, "invalid string length %q", lit)
+	} else if length < 0 {
+		return nil, d.syntaxError(start, "negative string length %d", length)
+	} else if length > d.limits.MaxStringLength {
+		return nil, d.syntaxError(start, "string length %d exceeds limit of %d", length, d.limits.MaxStringLength)
+	}
+
+	if length > len(d.data)-d.off {
+		return nil, d.eofError()
+	}
+
+	s := d.data[d.off : d.off+length]
+	d.off += length
+
+	return s, nil
+}
+
+// maxIntDigits bounds the length of integers decoded into a *big.Int,
+// as parsing them takes time quadratic in their length.
+const maxIntDigits = 1024
+
+// readIntLiteral reads a bencoded integer literal without its delimiters.
+// Example: i42e -> 42
+func (d *decodeState) readIntLiteral() (string, error) {
+	start := d.off
+
+	// Skip 'i'
+	d.off++
+
+	lit, err := d.readInt('e')
+	if err != nil {
+		return "", err
+	}
+
+	// readInt has ensured the literal is made of digits
+	// with an optional leading minus sign
+	digits := strings.TrimPrefix(lit, "-")
+	if digits == "" {
+		return "", d.syntaxError(start, "invalid integer %q", lit)
+	} else if len(digits) > maxIntDigits {
+		return "", d.syntaxError(start, "integer exceeds %d digits", maxIntDigits)
+	}
+
+	if d.strict {
+		if lit == "-0" {
+			return "", d.canonicalError(ErrNegativeZero, start)
+		}
+		if len(digits) > 1 && digits[0] == '0' {
+			return "", d.canonicalError(ErrLeadingZero, start)
+		}
+	}
+
+	return lit, nil
+}
+
+func (d *decodeState) stringValue(v reflect.Value) error {
+	start := d.off
+
+	s, err := d.readString()
+	if err != nil {
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(string(s))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			d.typeError("string", v.Type(), start)
+			break
+		}
+		b := make([]byte, len(s))
+		copy(b, s)
+		v.SetBytes(b)
+	case reflect.Array:
+		if v.Type().Elem().Kind() != reflect.Uint8 || v.Len() != len(s) {
+			d.typeError("string", v.Type(), start)
+			break
+		}
+		reflect.Copy(v, reflect.ValueOf(s))
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			d.typeError("string", v.Type(), start)
+			break
+		}
+		v.Set(reflect.ValueOf(string(s)))
+	default:
+		d.typeError("string", v.Type(), start)
+	}
+
+	return nil
+}
+
+func (d *decodeState) intValue(v reflect.Value) error {
+	start := d.off
+
+	lit, err := d.readIntLiteral()
+	if err != nil {
+		return err
+	}
+
+	if v.Type() == bigIntType {
+		b := v.Addr().Interface().(*big.Int)
+		b.SetString(lit, 10)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(lit, 10, 64)
+		if err != nil || v.OverflowInt(n) {
+			d.typeError("integer "+lit, v.Type(), start)
+			break
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(lit, 10, 64)
+		if err != nil || v.OverflowUint(n) {
+			d.typeError("integer "+lit, v.Type(), start)
+			break
+		}
+		v.SetUint(n)
+	case reflect.Bool:
+		switch lit {
+		case "0":
+			v.SetBool(false)
+		case "1":
+			v.SetBool(true)
+		default:
+			d.typeError("integer "+lit, v.Type(), start)
+		}
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			d.typeError("integer "+lit, v.Type(), start)
+			break
+		}
+		v.Set(reflect.ValueOf(parseInteger(lit)))
+	default:
+		d.typeError("integer "+lit, v.Type(), start)
+	}
+
+	return nil
+}
+
+func (d *decodeState) listValue(v reflect.Value) error {
+	start := d.off
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			list, err := d.listInterface()
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(list))
+			return nil
+		}
+		d.typeError("list", v.Type(), start)
+		return d.skip()
+	default:
+		d.typeError("list", v.Type(), start)
+		return d.skip()
+	}
+
+	if err := d.enter(d.off); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	// Skip 'l'
+	d.off++
+
+	i := 0
+	for {
+		ch, err := d.peek()
+		if err != nil {
+			return err
+		}
+
+		if ch == 'e' {
+			d.off++
+			break
+		}
+
+		if v.Kind() == reflect.Slice {
+			if i >= v.Cap() {
+				v.Grow(1)
+			}
+			if i >= v.Len() {
+				v.SetLen(i + 1)
+			}
+		}
+
+		if i < v.Len() {
+			if err := d.value(v.Index(i)); err != nil {
+				return err
+			}
+		} else if err := d.skip(); err != nil {
+			// Ran out of fixed array: skip the rest
+			return err
+		}
+
+		i++
+	}
+
+	if i < v.Len() {
+		if v.Kind() == reflect.Array {
+			// Zero the rest of the array
+			z := reflect.Zero(v.Type().Elem())
+			for ; i < v.Len(); i++ {
+				v.Index(i).Set(z)
+			}
+		} else {
+			v.SetLen(i)
+		}
+	}
+
+	if i == 0 && v.Kind() == reflect.Slice {
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	}
+
+	return nil
+}
+
+func (d *decodeState) dictValue(v reflect.Value) error {
+	start := d.off
+
+	var fields []field
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			dict, err := d.dictInterface()
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(dict))
+			return nil
+		}
+		d.typeError("dictionary", v.Type(), start)
+		return d.skip()
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			d.typeError("dictionary", v.Type(), start)
+			return d.skip()
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	case reflect.Struct:
+		fields = cachedTypeFields(v.Type())
+	default:
+		d.typeError("dictionary", v.Type(), start)
+		return d.skip()
+	}
+
+	if err := d.enter(d.off); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	// Skip 'd'
+	d.off++
+
+	origStruct, origField := d.errStruct, d.errField
+	defer func() {
+		d.errStruct, d.errField = origStruct, origField
+	}()
+
+	for {
+		ch, err := d.peek()
+		if err != nil {
+			return err
+		}
+
+		if ch == 'e' {
+			d.off++
+			break
+		}
+
+		key, err := d.readString()
+		if err != nil {
+			return err
+		}
+
+		if v.Kind() == reflect.Map {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			d.errStruct, d.errField = origStruct, string(key)
+
+			if err := d.value(elem); err != nil {
+				return err
+			}
+
+			kv := reflect.ValueOf(string(key)).Convert(v.Type().Key())
+			v.SetMapIndex(kv, elem)
+			continue
+		}
+
+		f := fieldByName(fields, string(key))
+		if f == nil {
+			if err := d.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fv, ok := allocFieldByIndex(v, f.index)
+		if !ok {
+			if err := d.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		d.errStruct, d.errField = v.Type().Name(), f.name
+
+		if err := d.value(fv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// allocFieldByIndex returns the nested field of v,
+// allocating nil embedded pointers on the way.
+func allocFieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+
+	return v, true
+}
+
+// valueInterface decodes the value at the current offset
+// into its generic Go representation.
+func (d *decodeState) valueInterface() (any, error) {
+	ch, err := d.peek()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case isDigit(ch) || ch == '-':
+		s, err := d.readString()
+		return string(s), err
+	case ch == 'i':
+		lit, err := d.readIntLiteral()
+		if err != nil {
+			return nil, err
+		}
+
+		return parseInteger(lit), nil
+	case ch == 'l':
+		return d.listInterface()
+	case ch == 'd':
+		return d.dictInterface()
+	default:
+		return nil, d.syntaxError(d.off, "invalid value type %q", ch)
+	}
+}
+
+func (d *decodeState) listInterface() ([]any, error) {
+	if err := d.enter(d.off); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	// Skip 'l'
+	d.off++
+
+	list := make([]any, 0)
+
+	for {
+		ch, err := d.peek()
+		if err != nil {
+			return nil, err
+		}
+
+		if ch == 'e' {
+			d.off++
+			return list, nil
+		}
+
+		value, err := d.valueInterface()
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, value)
+	}
+}
+
+func (d *decodeState) dictInterface() (map[string]any, error) {
+	if err := d.enter(d.off); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	// Skip 'd'
+	d.off++
+
+	dict := make(map[string]any)
+
+	for {
+		ch, err := d.peek()
+		if err != nil {
+			return nil, err
+		}
+
+		if ch == 'e' {
+			d.off++
+			return dict, nil
+		}
+
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := d.valueInterface()
+		if err != nil {
+			return nil, err
+		}
+
+		dict[string(key)] = value
+	}
+}
+
+// skip advances the offset past the value at the current offset
+// without decoding it.
+func (d *decodeState) skip() error {
+	ch, err := d.peek()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case isDigit(ch) || ch == '-':
+		_, err := d.readString()
+		return err
+	case ch == 'i':
+		_, err := d.readIntLiteral()
+		return err
+	case ch == 'l':
+		if err := d.enter(d.off); err != nil {
+			return err
+		}
+		defer d.leave()
+
+		// Skip 'l'
+		d.off++
+
+		for {
+			ch, err := d.peek()
+			if err != nil {
+				return err
+			}
+
+			if ch == 'e' {
+				d.off++
+				return nil
+			}
+
+			if err := d.skip(); err != nil {
+				return err
+			}
+		}
+	case ch == 'd':
+		if err := d.enter(d.off); err != nil {
+			return err
+		}
+		defer d.leave()
+
+		// Skip 'd'
+		d.off++
+
+		var prevKey []byte
+
+		for {
+			ch, err := d.peek()
+			if err != nil {
+				return err
+			}
+
+			if ch == 'e' {
+				d.off++
+				return nil
+			}
+
+			keyStart := d.off
+
+			key, err := d.readString()
+			if err != nil {
+				return err
+			}
+
+			// Keys must be unique and sorted as raw strings
+			if d.strict && prevKey != nil {
+				switch c := bytes.Compare(prevKey, key); {
+				case c == 0:
+					return d.canonicalError(ErrDuplicateKey, keyStart)
+				case c > 0:
+					return d.canonicalError(ErrUnsortedKeys, keyStart)
+				}
+			}
+			prevKey = key
+
+			if err := d.skip(); err != nil {
+				return err
+			}
+		}
+	default:
+		return d.syntaxError(d.off, "invalid value type %q", ch)
+	}
+}
+
+var bigIntType = reflect.TypeOf(big.Int{})
+
+// parseInteger returns the integer literal as an int64,
+// or as a *big.Int if it overflows 64 bits.
+func parseInteger(lit string) any {
+	if n, err := strconv.ParseInt(lit, 10, 64); err == nil {
+		return n
+	}
+
+	n, _ := new(big.Int).SetString(lit, 10)
+
+	return n
+}
+
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
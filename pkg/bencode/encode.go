@@ -0,0 +1,309 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Marshaler is the interface implemented by types that can encode
+// themselves into a valid bencoded value.
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+// UnsupportedTypeError is returned by Marshal when attempting
+// to encode a value of a type that has no bencode representation.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "bencode: unsupported type: " + e.Type.String()
+}
+
+// MarshalerError is returned by Marshal when a Marshaler fails.
+type MarshalerError struct {
+	Type reflect.Type
+	Err  error
+}
+
+func (e *MarshalerError) Error() string {
+	return fmt.Sprintf("bencode: error calling MarshalBencode for type %v: %v", e.Type, e.Err)
+}
+
+func (e *MarshalerError) Unwrap() error {
+	return e.Err
+}
+
+// Marshal returns the bencoding of v.
+//
+// Strings, byte slices and byte arrays are encoded as byte strings,
+// integers of any width and big.Int values as integers (booleans as 0 or 1),
+// slices and arrays as lists, and maps with string keys as dictionaries.
+// Structs are encoded as dictionaries keyed by the field name, which can
+// be customized with the "bencode" struct tag:
+//
+//	Length int    `bencode:"length"`
+//	MD5    string `bencode:"md5sum,omitempty"`
+//	Ignore int    `bencode:"-"`
+//
+// Nil pointers and interfaces inside dictionaries are omitted,
+// as bencode has no representation for null.
+func Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+
+	e := &encodeState{&buf}
+	if err := e.reflectValue(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodeWriter is implemented by both bytes.Buffer and bufio.Writer,
+// which record write errors for the caller to check once encoding is done.
+type encodeWriter interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+// encodeState writes the bencoding of values to the underlying writer.
+type encodeState struct {
+	w encodeWriter
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+func (e *encodeState) reflectValue(v reflect.Value) error {
+	if !v.IsValid() {
+		return fmt.Errorf("bencode: cannot encode nil value")
+	}
+
+	if v.Type().Implements(marshalerType) {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return fmt.Errorf("bencode: cannot encode nil %v", v.Type())
+		}
+
+		return e.marshaler(v.Interface().(Marshaler), v.Type())
+	}
+	if v.Kind() != reflect.Pointer && v.CanAddr() && reflect.PointerTo(v.Type()).Implements(marshalerType) {
+		return e.marshaler(v.Addr().Interface().(Marshaler), v.Type())
+	}
+
+	if v.Type() == bigIntType {
+		b := v.Interface().(big.Int)
+		e.writeBigInt(&b)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		e.writeString(v.String())
+	case reflect.Bool:
+		if v.Bool() {
+			e.w.WriteString("i1e")
+		} else {
+			e.w.WriteString("i0e")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeUint(v.Uint())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeBytes(v.Bytes())
+			return nil
+		}
+
+		return e.list(v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.writeBytes(b)
+			return nil
+		}
+
+		return e.list(v)
+	case reflect.Map:
+		return e.dict(v)
+	case reflect.Struct:
+		return e.structDict(v)
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return fmt.Errorf("bencode: cannot encode nil %v", v.Type())
+		}
+
+		return e.reflectValue(v.Elem())
+	default:
+		return &UnsupportedTypeError{v.Type()}
+	}
+
+	return nil
+}
+
+func (e *encodeState) marshaler(m Marshaler, t reflect.Type) error {
+	b, err := m.MarshalBencode()
+	if err != nil {
+		return &MarshalerError{t, err}
+	}
+
+	// Make sure the output is exactly one valid value,
+	// so it can't corrupt the surrounding encoding
+	d := newDecodeState(b, Limits{})
+	if err := d.skip(); err != nil || d.off != len(b) {
+		return &MarshalerError{t, errors.New("invalid bencoded output")}
+	}
+
+	e.w.Write(b)
+
+	return nil
+}
+
+// writeString writes a bencoded byte string.
+// Example: spam -> 4:spam
+func (e *encodeState) writeString(s string) {
+	e.w.WriteString(strconv.Itoa(len(s)))
+	e.w.WriteByte(':')
+	e.w.WriteString(s)
+}
+
+func (e *encodeState) writeBytes(b []byte) {
+	e.w.WriteString(strconv.Itoa(len(b)))
+	e.w.WriteByte(':')
+	e.w.Write(b)
+}
+
+// writeInt writes a bencoded integer.
+// Example: 42 -> i42e
+func (e *encodeState) writeInt(i int64) {
+	e.w.WriteByte('i')
+	e.w.WriteString(strconv.FormatInt(i, 10))
+	e.w.WriteByte('e')
+}
+
+func (e *encodeState) writeUint(u uint64) {
+	e.w.WriteByte('i')
+	e.w.WriteString(strconv.FormatUint(u, 10))
+	e.w.WriteByte('e')
+}
+
+func (e *encodeState) writeBigInt(b *big.Int) {
+	e.w.WriteByte('i')
+	e.w.WriteString(b.String())
+	e.w.WriteByte('e')
+}
+
+func (e *encodeState) list(v reflect.Value) error {
+	e.w.WriteByte('l')
+
+	for i := 0; i < v.Len(); i++ {
+		if err := e.reflectValue(v.Index(i)); err != nil {
+			return err
+		}
+	}
+
+	e.w.WriteByte('e')
+
+	return nil
+}
+
+func (e *encodeState) dict(v reflect.Value) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return &UnsupportedTypeError{v.Type()}
+	}
+
+	// Sort keys to ensure deterministic output
+	// when encoding the dictionary
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	e.w.WriteByte('d')
+
+	for _, k := range keys {
+		val := v.MapIndex(k)
+		if isNilValue(val) {
+			continue
+		}
+
+		e.writeString(k.String())
+
+		if err := e.reflectValue(val); err != nil {
+			return err
+		}
+	}
+
+	e.w.WriteByte('e')
+
+	return nil
+}
+
+func (e *encodeState) structDict(v reflect.Value) error {
+	e.w.WriteByte('d')
+
+	for _, f := range cachedTypeFields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || isNilValue(fv) || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+
+		e.writeString(f.name)
+
+		if err := e.reflectValue(fv); err != nil {
+			return err
+		}
+	}
+
+	e.w.WriteByte('e')
+
+	return nil
+}
+
+// fieldByIndex returns the nested field of v, reporting false
+// if it is reached through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+
+	return v, true
+}
+
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
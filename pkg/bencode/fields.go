@@ -0,0 +1,143 @@
+package bencode
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// field describes a struct field mapped to a dictionary key.
+type field struct {
+	name      string
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// cachedTypeFields is like typeFields but uses a cache to avoid repeated work.
+func cachedTypeFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+
+	return f.([]field)
+}
+
+// typeFields returns the fields that should be encoded for the given struct
+// type, sorted by key as required for bencoded dictionaries. Fields of
+// untagged embedded structs are promoted, with shallower fields taking
+// precedence over deeper ones of the same name.
+func typeFields(t reflect.Type) []field {
+	var fields []field
+
+	seen := make(map[string]bool)
+
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	current := []embedded{}
+	next := []embedded{{typ: t}}
+	visited := map[reflect.Type]bool{}
+
+	for len(next) > 0 {
+		current, next = next, current[:0]
+
+		for _, st := range current {
+			if visited[st.typ] {
+				continue
+			}
+			visited[st.typ] = true
+
+			for i := 0; i < st.typ.NumField(); i++ {
+				sf := st.typ.Field(i)
+
+				tag := sf.Tag.Get("bencode")
+				if tag == "-" {
+					continue
+				}
+
+				name, opts, _ := strings.Cut(tag, ",")
+
+				index := make([]int, len(st.index)+1)
+				copy(index, st.index)
+				index[len(st.index)] = i
+
+				ft := sf.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+
+				if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+					next = append(next, embedded{ft, index})
+					continue
+				}
+
+				if !sf.IsExported() {
+					continue
+				}
+
+				if name == "" {
+					name = sf.Name
+				}
+
+				// Shallower fields hide deeper ones with the same name
+				if seen[name] {
+					continue
+				}
+				seen[name] = true
+
+				fields = append(fields, field{
+					name:      name,
+					index:     index,
+					typ:       sf.Type,
+					omitEmpty: hasOption(opts, "omitempty"),
+				})
+			}
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].name < fields[j].name
+	})
+
+	return fields
+}
+
+func hasOption(opts, name string) bool {
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+
+		if opt == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fieldByName looks up the field for the dictionary key,
+// preferring an exact match over a case-insensitive one.
+func fieldByName(fields []field, key string) *field {
+	i := sort.Search(len(fields), func(i int) bool {
+		return fields[i].name >= key
+	})
+	if i < len(fields) && fields[i].name == key {
+		return &fields[i]
+	}
+
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, key) {
+			return &fields[i]
+		}
+	}
+
+	return nil
+}
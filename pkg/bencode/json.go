@@ -0,0 +1,236 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// BinaryFormat selects how ToJSON represents byte strings
+// that are not valid UTF-8.
+type BinaryFormat int
+
+const (
+	BinaryHex BinaryFormat = iota
+	BinaryBase64
+)
+
+// Keys of the tagged JSON objects standing in for byte strings and
+// dictionaries that have no plain JSON representation.
+const (
+	jsonHexTag    = "$hex"
+	jsonBase64Tag = "$base64"
+	jsonDictTag   = "$dict"
+)
+
+// ToJSON converts a decoded bencode value into a value that encoding/json
+// marshals reversibly, so that FromJSON can restore the original bencoding.
+//
+// Byte strings that are valid UTF-8 become JSON strings, other byte strings
+// become {"$hex": "..."} or {"$base64": "..."} objects depending on format.
+// Dictionaries become JSON objects, unless a key is not valid UTF-8 or could
+// be mistaken for a tag, in which case they become {"$dict": [[key, value], ...]}
+// with the pairs sorted by key.
+func ToJSON(v any, format BinaryFormat) any {
+	switch v := v.(type) {
+	case string:
+		return jsonString(v, format)
+	case []any:
+		list := make([]any, len(v))
+		for i, elem := range v {
+			list[i] = ToJSON(elem, format)
+		}
+		return list
+	case map[string]any:
+		if !isPlainJSONDict(v) {
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			pairs := make([]any, len(keys))
+			for i, k := range keys {
+				pairs[i] = []any{jsonString(k, format), ToJSON(v[k], format)}
+			}
+			return map[string]any{jsonDictTag: pairs}
+		}
+
+		dict := make(map[string]any, len(v))
+		for k, elem := range v {
+			dict[k] = ToJSON(elem, format)
+		}
+		return dict
+	default:
+		// Integers marshal as JSON numbers as they are
+		return v
+	}
+}
+
+func jsonString(s string, format BinaryFormat) any {
+	if utf8.ValidString(s) {
+		return s
+	}
+
+	if format == BinaryBase64 {
+		return map[string]any{jsonBase64Tag: base64.StdEncoding.EncodeToString([]byte(s))}
+	}
+
+	return map[string]any{jsonHexTag: hex.EncodeToString([]byte(s))}
+}
+
+// isPlainJSONDict reports whether the dictionary can be represented
+// as a JSON object without being mistaken for a tagged value.
+func isPlainJSONDict(m map[string]any) bool {
+	for k := range m {
+		if !utf8.ValidString(k) {
+			return false
+		}
+
+		if len(m) == 1 && (k == jsonHexTag || k == jsonBase64Tag || k == jsonDictTag) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FromJSON parses JSON in the form produced by ToJSON and returns the
+// decoded bencode value it represents, ready to be passed to Marshal.
+// JSON numbers must be integers; booleans become 0 or 1.
+func FromJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid JSON: trailing data after top-level value")
+	}
+
+	return fromJSONValue(v)
+}
+
+func fromJSONValue(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		if n, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+			return n, nil
+		}
+
+		n, ok := new(big.Int).SetString(v.String(), 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid integer %v: bencode has no floating point numbers", v)
+		}
+
+		return n, nil
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case []any:
+		list := make([]any, len(v))
+		for i, elem := range v {
+			val, err := fromJSONValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = val
+		}
+		return list, nil
+	case map[string]any:
+		if len(v) == 1 {
+			for tag, val := range v {
+				switch tag {
+				case jsonHexTag, jsonBase64Tag:
+					return fromJSONBytes(tag, val)
+				case jsonDictTag:
+					return fromJSONPairs(val)
+				}
+			}
+		}
+
+		dict := make(map[string]any, len(v))
+		for k, elem := range v {
+			val, err := fromJSONValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			dict[k] = val
+		}
+		return dict, nil
+	case nil:
+		return nil, fmt.Errorf("invalid null value: bencode has no null")
+	default:
+		return nil, fmt.Errorf("invalid JSON value %v", v)
+	}
+}
+
+func fromJSONBytes(tag string, v any) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("invalid %s value: expected string, got %v", tag, v)
+	}
+
+	var b []byte
+	var err error
+
+	if tag == jsonBase64Tag {
+		b, err = base64.StdEncoding.DecodeString(s)
+	} else {
+		b, err = hex.DecodeString(s)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid %s value: %v", tag, err)
+	}
+
+	return string(b), nil
+}
+
+func fromJSONPairs(v any) (map[string]any, error) {
+	pairs, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s value: expected list of pairs", jsonDictTag)
+	}
+
+	dict := make(map[string]any, len(pairs))
+
+	for _, p := range pairs {
+		pair, ok := p.([]any)
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("invalid %s value: expected [key, value] pair, got %v", jsonDictTag, p)
+		}
+
+		key, err := fromJSONValue(pair[0])
+		if err != nil {
+			return nil, err
+		}
+
+		k, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s key: expected string, got %v", jsonDictTag, pair[0])
+		}
+
+		val, err := fromJSONValue(pair[1])
+		if err != nil {
+			return nil, err
+		}
+
+		dict[k] = val
+	}
+
+	return dict, nil
+}
@@ -0,0 +1,35 @@
+package bencode
+
+// Default resource limits used when decoding. They are generous enough
+// for the largest .torrent files while keeping hostile input in check.
+const (
+	DefaultMaxStringLength = 128 << 20 // 128 MiB
+	DefaultMaxDepth        = 512
+	DefaultMaxSize         = 256 << 20 // 256 MiB
+)
+
+// Limits bound the resources used to decode a single top-level value.
+// A zero field means the corresponding default limit applies.
+type Limits struct {
+	// MaxStringLength is the longest byte string accepted
+	MaxStringLength int
+	// MaxDepth is the deepest nesting of lists and dictionaries accepted
+	MaxDepth int
+	// MaxSize is the largest encoded value a Decoder reads from its input
+	MaxSize int64
+}
+
+// withDefaults returns a copy of l with the zero fields set to the defaults.
+func (l Limits) withDefaults() Limits {
+	if l.MaxStringLength <= 0 {
+		l.MaxStringLength = DefaultMaxStringLength
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = DefaultMaxDepth
+	}
+	if l.MaxSize <= 0 {
+		l.MaxSize = DefaultMaxSize
+	}
+
+	return l
+}
@@ -0,0 +1,146 @@
+package bencode
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDefaultLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		offset int64 // -1 if the input is accepted
+	}{
+		{"string too long", "200000000:ab", 0},
+		{"string length overflow", "999999999999999999999:", 0},
+		{"deepest nesting", strings.Repeat("l", DefaultMaxDepth) + strings.Repeat("e", DefaultMaxDepth), -1},
+		{"nesting too deep", strings.Repeat("l", DefaultMaxDepth+1) + strings.Repeat("e", DefaultMaxDepth+1), DefaultMaxDepth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v any
+			checkSyntaxError(t, "Unmarshal", Unmarshal([]byte(tt.input), &v), tt.offset)
+			checkSyntaxError(t, "Decode", NewDecoder(strings.NewReader(tt.input)).Decode(&v), tt.offset)
+		})
+	}
+}
+
+func TestDecoderLimits(t *testing.T) {
+	limits := Limits{MaxStringLength: 4, MaxDepth: 2, MaxSize: 8}
+
+	tests := []struct {
+		name   string
+		input  string
+		offset int64 // -1 if the input is accepted
+	}{
+		{"longest string", "4:abcd", -1},
+		{"string too long", "5:abcde", 0},
+		{"nested string too long", "l5:abcdee", 1},
+		{"deepest nesting", "lli1eee", -1},
+		{"nesting too deep", "llleee", 2},
+		{"largest value", "li1ei2ee", -1},
+		{"value too large", "li1ei2ei3ee", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, r := range []io.Reader{strings.NewReader(tt.input), iotest.OneByteReader(strings.NewReader(tt.input))} {
+				dec := NewDecoder(r)
+				dec.SetLimits(limits)
+
+				var v any
+				checkSyntaxError(t, "Decode", dec.Decode(&v), tt.offset)
+			}
+		})
+	}
+}
+
+func TestDecoderMaxSizeStopsReading(t *testing.T) {
+	// A list that never ends
+	r := io.MultiReader(strings.NewReader("l"), iotest.OneByteReader(&repeatReader{s: "i1e"}))
+
+	dec := NewDecoder(r)
+	dec.SetLimits(Limits{MaxSize: 64})
+
+	var v any
+	checkSyntaxError(t, "Decode", dec.Decode(&v), 0)
+}
+
+func TestSyntaxErrorOffsets(t *testing.T) {
+	tests := []struct {
+		input  string
+		offset int64
+		eof    bool
+	}{
+		{"x", 0, false},
+		{"li1ex", 4, false},
+		{"i1-2e", 2, false},
+		{"ie", 0, false},
+		{"di1ei2ee", 1, false},
+		{"d1:ai1e1:be", 10, false},
+		{"i12", 3, true},
+		{"d1:a", 4, true},
+		{"5:abc", 5, true},
+		{"l", 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var v any
+
+			err := Unmarshal([]byte(tt.input), &v)
+			checkSyntaxError(t, "Unmarshal", err, tt.offset)
+			if got := errors.Is(err, io.ErrUnexpectedEOF); got != tt.eof {
+				t.Errorf("Unmarshal error is io.ErrUnexpectedEOF = %v, want %v", got, tt.eof)
+			}
+
+			err = NewDecoder(iotest.OneByteReader(strings.NewReader(tt.input))).Decode(&v)
+			checkSyntaxError(t, "Decode", err, tt.offset)
+			if got := errors.Is(err, io.ErrUnexpectedEOF); got != tt.eof {
+				t.Errorf("Decode error is io.ErrUnexpectedEOF = %v, want %v", got, tt.eof)
+			}
+		})
+	}
+}
+
+// checkSyntaxError checks that err is a *SyntaxError at the offset,
+// or nil if the offset is -1.
+func checkSyntaxError(t *testing.T, name string, err error, offset int64) {
+	t.Helper()
+
+	if offset < 0 {
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		return
+	}
+
+	var synErr *SyntaxError
+	if !errors.As(err, &synErr) {
+		t.Errorf("%s error = %v, want a *SyntaxError", name, err)
+		return
+	}
+
+	if synErr.Offset != offset {
+		t.Errorf("%s error offset = %d, want %d", name, synErr.Offset, offset)
+	}
+}
+
+// repeatReader reads its string over and over.
+type repeatReader struct {
+	s   string
+	off int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	for n := range p {
+		p[n] = r.s[r.off]
+		r.off = (r.off + 1) % len(r.s)
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,29 @@
+package bencode
+
+import "errors"
+
+// RawMessage is a raw encoded bencode value. It implements Marshaler and
+// Unmarshaler and can be used to delay decoding a value or to preserve the
+// exact bytes it was encoded with, e.g. to hash the info dictionary of a
+// torrent as it appears in the file.
+type RawMessage []byte
+
+// MarshalBencode returns m as the bencoding of m.
+func (m RawMessage) MarshalBencode() ([]byte, error) {
+	if len(m) == 0 {
+		return nil, errors.New("bencode: cannot encode empty RawMessage")
+	}
+
+	return m, nil
+}
+
+// UnmarshalBencode sets *m to a copy of data.
+func (m *RawMessage) UnmarshalBencode(data []byte) error {
+	if m == nil {
+		return errors.New("bencode: UnmarshalBencode on nil pointer")
+	}
+
+	*m = append((*m)[0:0], data...)
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Decoder reads and decodes bencoded values from an input stream.
+type Decoder struct {
+	r       io.Reader
+	buf     []byte
+	scanp   int   // start of unread data in buf
+	scanned int64 // amount of data already scanned before buf
+	err     error
+	strict  bool
+	limits  Limits
+	scan    scanState
+}
+
+// scanState is the progress of the scan for the end of the value at
+// dec.scanp, kept across refills so that input arriving in small reads
+// is only scanned once.
+type scanState struct {
+	off int // end of the tokens scanned so far, relative to dec.scanp
+	// stack holds the lists and dictionaries open at off: 'l' for a list,
+	// 'k' for a dictionary expecting a key and 'v' expecting a value
+	stack []byte
+}
+
+// NewDecoder returns a new decoder that reads from r.
+//
+// The decoder introduces its own buffering and may
+// read data from r beyond the bencoded values requested.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, limits: Limits{}.withDefaults()}
+}
+
+// SetLimits sets the resource limits applied to each decoded value.
+// Input exceeding them is reported as a *SyntaxError.
+func (dec *Decoder) SetLimits(limits Limits) {
+	dec.limits = limits.withDefaults()
+}
+
+// DisallowNonCanonical causes the Decoder to return a *NonCanonicalError
+// when a value is not in canonical form, as described for UnmarshalStrict.
+// Data following a value is left for the next call to Decode.
+func (dec *Decoder) DisallowNonCanonical() {
+	dec.strict = true
+}
+
+// Decode reads the next bencoded value from its input
+// and stores it in the value pointed to by v.
+//
+// See the documentation for Unmarshal for details about
+// the conversion of bencode into a Go value.
+func (dec *Decoder) Decode(v any) error {
+	if dec.err != nil {
+		return dec.err
+	}
+
+	n, err := dec.readValue()
+	if err != nil {
+		return err
+	}
+
+	d := dec.newDecodeState(dec.buf[dec.scanp : dec.scanp+n])
+	dec.scanp += n
+
+	return d.unmarshal(v)
+}
+
+// More reports whether there is another value in the input stream.
+func (dec *Decoder) More() bool {
+	return dec.peek() == nil
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's buffer.
+// The reader is valid until the next call to Decode.
+func (dec *Decoder) Buffered() io.Reader {
+	return bytes.NewReader(dec.buf[dec.scanp:])
+}
+
+// InputOffset returns the number of bytes of the input stream
+// consumed by the values decoded so far.
+func (dec *Decoder) InputOffset() int64 {
+	return dec.scanned + int64(dec.scanp)
+}
+
+// readValue reads a complete bencoded value into dec.buf
+// and returns its length.
+func (dec *Decoder) readValue() (int, error) {
+	dec.scan = scanState{stack: dec.scan.stack[:0]}
+
+	for {
+		done, err := dec.scanValue()
+		if done && dec.strict {
+			// Check the canonical form, which the scan leaves out
+			d := dec.newDecodeState(dec.buf[dec.scanp : dec.scanp+dec.scan.off])
+			d.strict = true
+			err = d.skip()
+		}
+
+		if done && err == nil && int64(dec.scan.off) <= dec.limits.MaxSize {
+			return dec.scan.off, nil
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			dec.err = err
+			return 0, err
+		}
+
+		if done || int64(len(dec.buf)-dec.scanp) >= dec.limits.MaxSize {
+			dec.err = &SyntaxError{
+				msg:    fmt.Sprintf("value size exceeds limit of %d", dec.limits.MaxSize),
+				Offset: dec.InputOffset(),
+			}
+			return 0, dec.err
+		}
+
+		// The value is incomplete, read more data into the buffer
+		if err := dec.refill(); err != nil {
+			if err == io.EOF && len(dec.buf) > dec.scanp {
+				err = dec.newDecodeState(dec.buf[dec.scanp:]).eofError()
+			}
+			dec.err = err
+			return 0, err
+		}
+	}
+}
+
+// scanValue scans the buffered tokens of the value at dec.scanp from where
+// the last scan stopped, reporting whether the value is complete. An error
+// wrapping io.ErrUnexpectedEOF means more input is needed, a token cut
+// short by the end of the buffer being scanned again after a refill.
+func (dec *Decoder) scanValue() (done bool, err error) {
+	s := &dec.scan
+	d := dec.newDecodeState(dec.buf[dec.scanp:])
+
+	for {
+		d.off = s.off
+
+		ch, err := d.peek()
+		if err != nil {
+			return false, err
+		}
+
+		top := len(s.stack) - 1
+
+		switch {
+		case top >= 0 && s.stack[top] != 'v' && ch == 'e':
+			s.stack = s.stack[:top]
+			d.off++
+		case top >= 0 && s.stack[top] == 'k':
+			if _, err := d.readString(); err != nil {
+				return false, err
+			}
+			s.stack[top] = 'v'
+			s.off = d.off
+			continue
+		case ch == 'l' || ch == 'd':
+			d.depth = len(s.stack)
+			if err := d.enter(d.off); err != nil {
+				return false, err
+			}
+			if ch == 'd' {
+				ch = 'k'
+			}
+			s.stack = append(s.stack, ch)
+			s.off = d.off + 1
+			continue
+		case ch == 'i':
+			if _, err := d.readIntLiteral(); err != nil {
+				return false, err
+			}
+		case isDigit(ch) || ch == '-':
+			if _, err := d.readString(); err != nil {
+				return false, err
+			}
+		default:
+			return false, d.syntaxError(d.off, "invalid value type %q", ch)
+		}
+
+		// A value is complete
+		s.off = d.off
+
+		if len(s.stack) == 0 {
+			return true, nil
+		}
+		if top := len(s.stack) - 1; s.stack[top] == 'v' {
+			s.stack[top] = 'k'
+		}
+	}
+}
+
+func (dec *Decoder) newDecodeState(data []byte) *decodeState {
+	return &decodeState{
+		data:   data,
+		base:   dec.InputOffset(),
+		limits: dec.limits,
+		strict: dec.strict,
+	}
+}
+
+func (dec *Decoder) refill() error {
+	// Make room to read more into the buffer.
+	// First slide down data already consumed.
+	if dec.scanp > 0 {
+		dec.scanned += int64(dec.scanp)
+		n := copy(dec.buf, dec.buf[dec.scanp:])
+		dec.buf = dec.buf[:n]
+		dec.scanp = 0
+	}
+
+	// Grow buffer if not large enough.
+	const minRead = 512
+	if cap(dec.buf)-len(dec.buf) < minRead {
+		newBuf := make([]byte, len(dec.buf), 2*cap(dec.buf)+minRead)
+		copy(newBuf, dec.buf)
+		dec.buf = newBuf
+	}
+
+	n, err := dec.r.Read(dec.buf[len(dec.buf):cap(dec.buf)])
+	dec.buf = dec.buf[0 : len(dec.buf)+n]
+
+	if n > 0 && err == io.EOF {
+		// Report EOF on the next read, once buffered data is used up
+		return nil
+	}
+
+	return err
+}
+
+// peek ensures there is at least one unread byte in the buffer.
+func (dec *Decoder) peek() error {
+	for dec.scanp >= len(dec.buf) {
+		if dec.err != nil {
+			return dec.err
+		}
+
+		if err := dec.refill(); err != nil {
+			dec.err = err
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Encoder writes bencoded values to an output stream.
+type Encoder struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the bencoding of v to the stream.
+//
+// See the documentation for Marshal for details about
+// the conversion of Go values to bencode.
+func (enc *Encoder) Encode(v any) error {
+	// Encode into a buffer first, so that nothing
+	// is written of a value that fails to encode
+	enc.buf.Reset()
+	e := &encodeState{&enc.buf}
+
+	if err := e.reflectValue(reflect.ValueOf(v)); err != nil {
+		return err
+	}
+
+	_, err := enc.w.Write(enc.buf.Bytes())
+	return err
+}
@@ -0,0 +1,86 @@
+package bencode
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestEncoderDiscardsFailedValue(t *testing.T) {
+	var out bytes.Buffer
+	enc := NewEncoder(&out)
+
+	if err := enc.Encode([]any{"ok", make(chan int)}); err == nil {
+		t.Fatal("Encode of a channel succeeded")
+	}
+	if err := enc.Encode(1); err != nil {
+		t.Fatalf("Encode(1): %v", err)
+	}
+
+	if got, want := out.String(), "i1e"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestDecoderSmallReads(t *testing.T) {
+	const input = "d1:ai-3e1:bl3:xyzd1:ci1eeee4:spami0e"
+
+	whole := NewDecoder(strings.NewReader(input))
+	bytewise := NewDecoder(iotest.OneByteReader(strings.NewReader(input)))
+
+	for whole.More() {
+		var want, got any
+		if err := whole.Decode(&want); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if err := bytewise.Decode(&got); err != nil {
+			t.Fatalf("Decode of one byte reads: %v", err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Decode of one byte reads = %#v, want %#v", got, want)
+		}
+	}
+
+	if bytewise.More() {
+		t.Error("More after the last value = true, want false")
+	}
+}
+
+func TestDecoderSmallReadsErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		strict bool
+	}{
+		{"l1:ai1x", false},
+		{"di1ei2ee", false},
+		{"d1:ae", false},
+		{"d1:bi1e1:ai2ee", true},
+		{"l3:ab", false},
+	}
+
+	for _, tt := range tests {
+		var want, got error
+		for i, r := range []io.Reader{strings.NewReader(tt.input), iotest.OneByteReader(strings.NewReader(tt.input))} {
+			dec := NewDecoder(r)
+			if tt.strict {
+				dec.DisallowNonCanonical()
+			}
+
+			var v any
+			err := dec.Decode(&v)
+			if i == 0 {
+				want = err
+			} else {
+				got = err
+			}
+		}
+
+		if want == nil || got == nil || got.Error() != want.Error() {
+			t.Errorf("%q: Decode of one byte reads error = %v, want %v", tt.input, got, want)
+		}
+	}
+}
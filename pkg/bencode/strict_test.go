@@ -0,0 +1,96 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStrictRejectsNonCanonical(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		err    error
+		offset int64
+	}{
+		{"leading zero", "i03e", ErrLeadingZero, 0},
+		{"negative leading zero", "i-03e", ErrLeadingZero, 0},
+		{"zero with leading zero", "i00e", ErrLeadingZero, 0},
+		{"negative zero", "i-0e", ErrNegativeZero, 0},
+		{"length with leading zero", "00:", ErrLeadingZero, 0},
+		{"nested leading zero", "li1ei03ee", ErrLeadingZero, 4},
+		{"nested length with leading zero", "l01:ae", ErrLeadingZero, 1},
+		{"negative length", "-1:", ErrNegativeLength, 0},
+		{"unsorted keys", "d1:bi1e1:ai2ee", ErrUnsortedKeys, 7},
+		{"unsorted prefix key", "d2:ab0:1:a0:e", ErrUnsortedKeys, 7},
+		{"duplicate key", "d1:ai1e1:ai2ee", ErrDuplicateKey, 7},
+		{"duplicate key after string", "d1:a3:abc1:a0:e", ErrDuplicateKey, 9},
+		{"trailing data", "i1ex", ErrTrailingData, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v any
+			checkNonCanonical(t, "UnmarshalStrict", UnmarshalStrict([]byte(tt.input), &v), tt.err, tt.offset)
+			if v != nil {
+				t.Errorf("UnmarshalStrict stored %#v", v)
+			}
+
+			// Decoders leave the data after a value for the next Decode
+			if tt.err == ErrTrailingData {
+				return
+			}
+
+			dec := NewDecoder(strings.NewReader(tt.input))
+			dec.DisallowNonCanonical()
+			checkNonCanonical(t, "Decode", dec.Decode(&v), tt.err, tt.offset)
+		})
+	}
+}
+
+func TestStrictDecoderOffsets(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("i1ed1:ai1e1:ai2ee"))
+	dec.DisallowNonCanonical()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	// Offsets are in the stream, not in the value
+	checkNonCanonical(t, "Decode", dec.Decode(&v), ErrDuplicateKey, 10)
+}
+
+func TestStrictAcceptsCanonical(t *testing.T) {
+	const input = "d1:ai-3e1:bl0:i0ee2:bbd1:ci10eee"
+
+	var want, got any
+	if err := Unmarshal([]byte(input), &want); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if err := UnmarshalStrict([]byte(input), &got); err != nil {
+		t.Fatalf("UnmarshalStrict: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmarshalStrict = %#v, want %#v", got, want)
+	}
+}
+
+func checkNonCanonical(t *testing.T, name string, err error, want error, offset int64) {
+	t.Helper()
+
+	var ncErr *NonCanonicalError
+	if !errors.As(err, &ncErr) {
+		t.Errorf("%s error = %v, want a *NonCanonicalError", name, err)
+		return
+	}
+
+	if !errors.Is(err, want) {
+		t.Errorf("%s error = %v, want %v", name, ncErr.Err, want)
+	}
+	if ncErr.Offset != offset {
+		t.Errorf("%s error offset = %d, want %d", name, ncErr.Offset, offset)
+	}
+}
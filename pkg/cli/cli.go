@@ -1,17 +1,26 @@
 package cli
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/codecrafters-io/bittorrent-starter-go/pkg/bencode"
 	"github.com/codecrafters-io/bittorrent-starter-go/pkg/magnet"
 	"github.com/codecrafters-io/bittorrent-starter-go/pkg/metainfo"
 	"github.com/codecrafters-io/bittorrent-starter-go/pkg/peer"
 	"github.com/codecrafters-io/bittorrent-starter-go/pkg/torrent"
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/tracker"
 	"github.com/codecrafters-io/bittorrent-starter-go/pkg/util"
 )
 
@@ -19,10 +28,18 @@ func ProcessCommand(command string) error {
 	switch command {
 	case "decode":
 		return decodeCommand()
+	case "encode":
+		return encodeCommand()
 	case "info":
 		return infoCommand()
+	case "create":
+		return createCommand()
 	case "peers":
 		return peersCommand()
+	case "scrape":
+		return scrapeCommand()
+	case "tracker":
+		return trackerCommand()
 	case "handshake":
 		return handshakeCommand()
 	case "download_piece":
@@ -39,48 +56,38 @@ func ProcessCommand(command string) error {
 		return magnetDownloadPieceCommand()
 	case "magnet_download":
 		return magnetDownloadCommand()
+	case "magnet_to_torrent":
+		return magnetToTorrentCommand()
 	default:
 		return fmt.Errorf("unknown command: %v", command)
 	}
 }
 
 func magnetDownloadCommand() error {
-	outFilename, magnetLink, err := parseMagnetDownloadArgs()
+	outFilename, torrentFile, magnetLink, err := parseMagnetDownloadArgs()
 	if err != nil {
 		return fmt.Errorf("failed to parse download piece args: %v", err)
 	}
 
-	infoHash, _, trackerURL, err := magnet.ParseMagnetLink(magnetLink)
+	m, err := magnet.Parse(magnetLink)
 	if err != nil {
 		return fmt.Errorf("failed to parse magnet link: %v", err)
 	}
 
-	peersInfo, err := peer.DiscoverPeers(trackerURL, infoHash, 1)
+	mf, err := fetchMagnetMetaFile(m)
 	if err != nil {
-		return fmt.Errorf("failed to discover peers: %v", err)
-	}
-	p := peersInfo[0]
-
-	pc, err := peer.NewPeerConnWithExtension(p, infoHash)
-	if err != nil {
-		return fmt.Errorf("failed to create peer connection: %v", err)
+		return err
 	}
 
-	metadataPiece, err := pc.RequestMetadata()
-	if err != nil {
-		return fmt.Errorf("failed to request metadata: %v", err)
-	}
-	pc.Close()
+	if torrentFile != "" {
+		if err := writeMetaFile(torrentFile, mf); err != nil {
+			return err
+		}
 
-	mf, err := metainfo.NewMetaFileFromMap(map[string]any{
-		"announce": trackerURL,
-		"info":     metadataPiece.Payload["meta_piece"],
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create metafile: %v", err)
+		fmt.Printf("Torrent saved to: %v\n", torrentFile)
 	}
 
-	torrent, err := torrent.NewTorrent(mf)
+	torrent, err := torrent.NewTorrentWithPeers(mf, magnetPeers(m))
 	if err != nil {
 		return fmt.Errorf("failed to create torrent: %v", err)
 	}
@@ -95,19 +102,24 @@ func magnetDownloadCommand() error {
 	return nil
 }
 
-func parseMagnetDownloadArgs() (outFile, magnetLink string, err error) {
-	if len(os.Args) < 5 {
-		err = fmt.Errorf("not enough arguments: expected 'mybittorrent download -o <out_file> <magnet_link>'")
+func parseMagnetDownloadArgs() (outFile, torrentFile, magnetLink string, err error) {
+	const usage = "mybittorrent magnet_download -o <out_file> [--save-torrent <torrent_file>] <magnet_link>"
+
+	flags := flag.NewFlagSet("magnet_download", flag.ContinueOnError)
+	flags.StringVar(&outFile, "o", "", "write the downloaded file to this path")
+	flags.StringVar(&torrentFile, "save-torrent", "", "also save the metadata of the magnet link as a .torrent file")
+
+	if err = flags.Parse(os.Args[2:]); err != nil {
 		return
 	}
 
-	outFile, magnetLink = os.Args[3], os.Args[4]
-	if magnetLink == "" {
-		err = fmt.Errorf("not enough arguments")
+	magnetLink = flags.Arg(0)
+	if outFile == "" || magnetLink == "" {
+		err = fmt.Errorf("not enough arguments: expected '%s'", usage)
 		return
 	}
 
-	pieceOutPath := outFile[:strings.LastIndex(outFile, "/")]
+	pieceOutPath := filepath.Dir(outFile)
 
 	// Create piece output file directory if it doesn't exist
 	if _, err = os.Stat(outFile); err != nil {
@@ -122,42 +134,79 @@ func parseMagnetDownloadArgs() (outFile, magnetLink string, err error) {
 	return
 }
 
+// magnetToTorrentCommand fetches the metadata of a magnet link from peers
+// and saves it along with the trackers of the magnet link as a .torrent file.
+func magnetToTorrentCommand() error {
+	const usage = "mybittorrent magnet_to_torrent -o <out_file> <magnet_link>"
+
+	flags := flag.NewFlagSet("magnet_to_torrent", flag.ContinueOnError)
+	outFile := flags.String("o", "", "write the .torrent file to this path")
+
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	if *outFile == "" || flags.NArg() < 1 {
+		return fmt.Errorf("not enough arguments: expected '%s'", usage)
+	}
+
+	m, err := magnet.Parse(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to parse magnet link: %v", err)
+	}
+
+	mf, err := fetchMagnetMetaFile(m)
+	if err != nil {
+		return err
+	}
+
+	if err := writeMetaFile(*outFile, mf); err != nil {
+		return err
+	}
+
+	fmt.Printf("Torrent saved to: %v\n", *outFile)
+	fmt.Printf("Info Hash: %x\n", mf.Info.Hash)
+
+	return nil
+}
+
 func magnetDownloadPieceCommand() error {
 	outFile, magnetLink, pieceIdx, err := parseMagnetDownloadPieceArgs()
 	if err != nil {
 		return fmt.Errorf("failed to parse download piece args: %v", err)
 	}
 
-	infoHash, _, trackerURL, err := magnet.ParseMagnetLink(magnetLink)
+	m, err := magnet.Parse(magnetLink)
 	if err != nil {
 		return fmt.Errorf("failed to parse magnet link: %v", err)
 	}
 
-	peersInfo, err := peer.DiscoverPeers(trackerURL, infoHash, 1)
+	peersInfo, err := discoverMagnetPeers(m)
 	if err != nil {
 		return fmt.Errorf("failed to discover peers: %v", err)
 	}
 	p := peersInfo[0]
 
-	pc, err := peer.NewPeerConnWithExtension(p, infoHash)
+	pc, err := peer.NewPeerConnWithExtension(p, m.Hash(), true)
 	if err != nil {
 		return fmt.Errorf("failed to create peer connection: %v", err)
 	}
 	defer pc.Close()
 
-	metadataPiece, err := pc.RequestMetadata()
+	metadata, err := pc.RequestMetadata(m.Hash())
 	if err != nil {
 		return fmt.Errorf("failed to request metadata: %v", err)
 	}
 
-	mf, err := metainfo.NewMetaFileFromMap(map[string]any{
-		"announce": trackerURL,
-		"info":     metadataPiece.Payload["meta_piece"],
-	})
+	mf, err := magnetMetaFile(m, metadata)
 	if err != nil {
 		return fmt.Errorf("failed to create metafile: %v", err)
 	}
 
+	if err := pc.FetchPieceLayers(mf); err != nil {
+		return fmt.Errorf("failed to get piece layers: %v", err)
+	}
+
 	if err := pc.PreDownload(); err != nil {
 		return fmt.Errorf("failed to prepare download: %v", err)
 	}
@@ -218,40 +267,19 @@ func magnetInfoCommand() error {
 
 	magnetLink := os.Args[2]
 
-	infoHash, _, trackerURL, err := magnet.ParseMagnetLink(magnetLink)
+	m, err := magnet.Parse(magnetLink)
 	if err != nil {
 		return fmt.Errorf("failed to parse magnet link: %v", err)
 	}
 
-	peersInfo, err := peer.DiscoverPeers(trackerURL, infoHash, 1)
-	if err != nil {
-		return fmt.Errorf("failed to discover peers: %v", err)
-	}
-	p := peersInfo[0]
-
-	pc, err := peer.NewPeerConnWithExtension(p, infoHash)
+	mf, err := fetchMagnetMetaFile(m)
 	if err != nil {
-		return fmt.Errorf("failed to create peer connection: %v", err)
-	}
-	defer pc.Close()
-
-	metadataPiece, err := pc.RequestMetadata()
-	if err != nil {
-		return fmt.Errorf("failed to request metadata: %v", err)
+		return err
 	}
 
-	mf, err := metainfo.NewMetaFileFromMap(map[string]any{
-		"announce": trackerURL,
-		"info":     metadataPiece.Payload["meta_piece"],
-	})
+	printMetaFile(mf)
 
-	fmt.Printf("Tracker URL: %v\n", mf.Announce)
-	fmt.Printf("Length: %v\n", mf.Info.Length)
-	fmt.Printf("Info Hash: %x\n", mf.Info.Hash)
-	fmt.Printf("Piece Length: %v\n", mf.Info.PieceLength)
-	fmt.Printf("Piece Hashes:\n%v\n", strings.Join(mf.Info.PieceHashes, "\n"))
-
-	return err
+	return nil
 }
 
 func magnetHandshakeCommand() error {
@@ -261,18 +289,18 @@ func magnetHandshakeCommand() error {
 
 	magnetLink := os.Args[2]
 
-	infoHash, _, trackerURL, err := magnet.ParseMagnetLink(magnetLink)
+	m, err := magnet.Parse(magnetLink)
 	if err != nil {
 		return fmt.Errorf("failed to parse magnet link: %v", err)
 	}
 
-	peersInfo, err := peer.DiscoverPeers(trackerURL, infoHash, 1)
+	peersInfo, err := discoverMagnetPeers(m)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to discover peers: %v", err)
 	}
 	p := peersInfo[0]
 
-	pc, err := peer.NewPeerConnWithExtension(p, infoHash)
+	pc, err := peer.NewPeerConnWithExtension(p, m.Hash(), true)
 	if err != nil {
 		return fmt.Errorf("failed to create peer connection: %v", err)
 	}
@@ -294,20 +322,133 @@ func magnetParseCommand() error {
 		return fmt.Errorf("not enough arguments: expected 'mybittorrent magnet_parse <magnet_link>'")
 	}
 
-	matnetLink := os.Args[2]
-
-	infoHash, filename, trackerURL, err := magnet.ParseMagnetLink(matnetLink)
+	m, err := magnet.Parse(os.Args[2])
 	if err != nil {
 		return fmt.Errorf("failed to parse magnet link: %v", err)
 	}
 
-	fmt.Printf("Tracker URL: %v\n", trackerURL)
-	fmt.Printf("Info Hash: %x\n", infoHash)
-	fmt.Printf("Filename: %v\n", filename)
+	if len(m.Trackers) > 0 {
+		fmt.Printf("Tracker URL: %v\n", m.Trackers[0])
+	}
+	if m.InfoHash != "" {
+		fmt.Printf("Info Hash: %x\n", m.InfoHash)
+	}
+	if m.InfoHashV2 != "" {
+		fmt.Printf("Info Hash v2: %x\n", m.InfoHashV2)
+	}
+	if m.DisplayName != "" {
+		fmt.Printf("Filename: %v\n", m.DisplayName)
+	}
+	if m.Length > 0 {
+		fmt.Printf("Length: %v\n", m.Length)
+	}
+	for _, tracker := range m.Trackers[min(1, len(m.Trackers)):] {
+		fmt.Printf("Tracker URL: %v\n", tracker)
+	}
+	for _, ws := range m.WebSeeds {
+		fmt.Printf("Web Seed: %v\n", ws)
+	}
+	for _, pe := range m.Peers {
+		fmt.Printf("Peer: %v\n", pe)
+	}
+	if len(m.SelectOnly) > 0 {
+		fmt.Printf("Selected Files: %v\n", m.SelectOnly)
+	}
 
 	return nil
 }
 
+// discoverMagnetPeers returns the peers listed in the magnet link,
+// followed by the peers from its trackers.
+func discoverMagnetPeers(m *magnet.Magnet) ([]peer.Peer, error) {
+	peers := magnetPeers(m)
+
+	if len(m.Trackers) == 0 {
+		if len(peers) == 0 {
+			return nil, fmt.Errorf("no peers in magnet link and no trackers to ask")
+		}
+
+		return peers, nil
+	}
+
+	trackerPeers, err := tracker.DiscoverPeers(magnetTrackerTiers(m), m.Hash(), max(m.Length, 1))
+	if err != nil && len(peers) == 0 {
+		return nil, err
+	}
+
+	peers = append(peers, trackerPeers...)
+
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("no peers in magnet link and its trackers returned no peers")
+	}
+
+	return peers, nil
+}
+
+// magnetPeers returns the peers listed in the magnet link,
+// skipping invalid addresses.
+func magnetPeers(m *magnet.Magnet) []peer.Peer {
+	var peers []peer.Peer
+
+	for _, addr := range m.Peers {
+		p, err := peer.NewPeerFromAddr(addr)
+		if err != nil {
+			log.Printf("Skipping peer %v: %v\n", addr, err)
+			continue
+		}
+
+		peers = append(peers, *p)
+	}
+
+	return peers
+}
+
+// magnetTrackerTiers puts each tracker of the magnet link in a tier of
+// its own, so that all of them are asked for peers.
+func magnetTrackerTiers(m *magnet.Magnet) [][]string {
+	tiers := make([][]string, len(m.Trackers))
+	for i, tracker := range m.Trackers {
+		tiers[i] = []string{tracker}
+	}
+
+	return tiers
+}
+
+// fetchMagnetMetaFile gets the info dictionary of the magnet link from
+// its peers and creates the MetaFile of the magnet link.
+func fetchMagnetMetaFile(m *magnet.Magnet) (*metainfo.MetaFile, error) {
+	peersInfo, err := discoverMagnetPeers(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover peers: %v", err)
+	}
+
+	metadata, err := peer.FetchMetadata(peersInfo, m.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to request metadata: %v", err)
+	}
+
+	mf, err := magnetMetaFile(m, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metafile: %v", err)
+	}
+
+	return mf, nil
+}
+
+// magnetMetaFile creates the MetaFile of the magnet link
+// from the verified info dictionary received from peers.
+func magnetMetaFile(m *magnet.Magnet, info []byte) (*metainfo.MetaFile, error) {
+	file := map[string]any{
+		"announce-list": magnetTrackerTiers(m),
+		"info":          bencode.RawMessage(info),
+	}
+	if len(m.WebSeeds) > 0 {
+		file["url-list"] = m.WebSeeds
+	}
+
+	return metainfo.NewMetaFileFromMap(file)
+}
+
 func downloadCommand() error {
 	outFilename, filename, err := parseDownloadArgs()
 	if err != nil {
@@ -339,7 +480,7 @@ func downloadCommand() error {
 
 func parseDownloadArgs() (outFile, filename string, err error) {
 	if len(os.Args) < 5 {
-		err = fmt.Errorf("not enough arguments: expected 'mybittorrent download -o <out_file> <torrent_file>'")
+		err = fmt.Errorf("not enough arguments: expected 'mybittorrent download -o <out_file|out_dir> <torrent_file>'")
 		return
 	}
 
@@ -349,7 +490,7 @@ func parseDownloadArgs() (outFile, filename string, err error) {
 		return
 	}
 
-	pieceOutPath := outFile[:strings.LastIndex(outFile, "/")]
+	pieceOutPath := filepath.Dir(outFile)
 
 	// Create piece output file directory if it doesn't exist
 	if _, err = os.Stat(outFile); err != nil {
@@ -375,7 +516,7 @@ func downloadPieceCommand() error {
 		return fmt.Errorf("failed to parse metafile: %v", err)
 	}
 
-	peersInfo, err := peer.DiscoverPeers(mf.Announce, mf.Info.Hash, mf.Info.Length)
+	peersInfo, err := tracker.DiscoverPeers(mf.Trackers(), mf.Info.Hash, mf.Info.Length)
 	if err != nil {
 		return err
 	}
@@ -389,6 +530,10 @@ func downloadPieceCommand() error {
 
 	defer pc.Close()
 
+	if err := pc.FetchPieceLayers(mf); err != nil {
+		return fmt.Errorf("failed to get piece layers: %v", err)
+	}
+
 	if err := pc.PreDownload(); err != nil {
 		return fmt.Errorf("failed to prepare download: %v", err)
 	}
@@ -471,19 +616,176 @@ func handshakeCommand() error {
 }
 
 func decodeCommand() error {
-	bencodedValue := os.Args[2]
+	flags := flag.NewFlagSet("decode", flag.ContinueOnError)
+	inFile := flags.String("i", "", "read the bencoded value from a file ('-' for stdin)")
+	useBase64 := flags.Bool("base64", false, "represent binary strings as base64 instead of hex")
+	indent := flags.Bool("indent", false, "indent the JSON output")
+
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
 
-	decoded, err := bencode.DecodeStr(bencodedValue)
+	data, err := readCommandInput(flags, *inFile, "mybittorrent decode [-i <file>] [<bencoded_value>]")
 	if err != nil {
 		return err
 	}
 
-	jsonOutput, _ := json.Marshal(decoded)
+	decoded, err := bencode.DecodeBytes(data)
+	if err != nil {
+		return err
+	}
+
+	format := bencode.BinaryHex
+	if *useBase64 {
+		format = bencode.BinaryBase64
+	}
+
+	var jsonOutput []byte
+	if *indent {
+		jsonOutput, err = json.MarshalIndent(bencode.ToJSON(decoded, format), "", "  ")
+	} else {
+		jsonOutput, err = json.Marshal(bencode.ToJSON(decoded, format))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %v", err)
+	}
+
 	fmt.Println(string(jsonOutput))
 
 	return nil
 }
 
+func encodeCommand() error {
+	flags := flag.NewFlagSet("encode", flag.ContinueOnError)
+	inFile := flags.String("i", "", "read the JSON value from a file ('-' for stdin)")
+	outFile := flags.String("o", "", "write the bencoded value to a file instead of stdout")
+
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	data, err := readCommandInput(flags, *inFile, "mybittorrent encode [-i <file>] [-o <out_file>] [<json_value>]")
+	if err != nil {
+		return err
+	}
+
+	value, err := bencode.FromJSON(data)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := bencode.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode value: %v", err)
+	}
+
+	if *outFile != "" {
+		return util.WriteToOut(*outFile, encoded)
+	}
+
+	_, err = os.Stdout.Write(encoded)
+
+	return err
+}
+
+// readCommandInput returns the contents of inFile if set,
+// or else the first positional argument of the command.
+func readCommandInput(flags *flag.FlagSet, inFile, usage string) ([]byte, error) {
+	switch {
+	case inFile == "-":
+		return io.ReadAll(os.Stdin)
+	case inFile != "":
+		return os.ReadFile(inFile)
+	case flags.NArg() > 0:
+		return []byte(flags.Arg(0)), nil
+	default:
+		return nil, fmt.Errorf("not enough arguments: expected '%s'", usage)
+	}
+}
+
+// stringList is a flag that can be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func createCommand() error {
+	const usage = "mybittorrent create -o <out_file> [-a <tracker_url,...>]... [options] <file|dir>"
+
+	var trackers, webSeeds stringList
+
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	outFile := flags.String("o", "", "write the .torrent file to this path")
+	flags.Var(&trackers, "a", "comma-separated tracker URLs of a tier, repeat for more tiers")
+	flags.Var(&webSeeds, "w", "web seed URL, can be repeated")
+	name := flags.String("n", "", "name of the torrent (default: base name of the path)")
+	comment := flags.String("c", "", "comment")
+	createdBy := flags.String("created-by", metainfo.DefaultCreatedBy, "created by")
+	noDate := flags.Bool("no-date", false, "omit the creation date")
+	private := flags.Bool("p", false, "mark the torrent as private")
+	source := flags.String("s", "", "source tag")
+	pieceLength := flags.Int("l", 0, "piece length in bytes (default: picked from the total size)")
+
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	if *outFile == "" || flags.NArg() < 1 {
+		return fmt.Errorf("not enough arguments: expected '%s'", usage)
+	}
+
+	b := &metainfo.Builder{
+		Name:        *name,
+		Comment:     *comment,
+		CreatedBy:   *createdBy,
+		Private:     *private,
+		Source:      *source,
+		WebSeeds:    webSeeds,
+		PieceLength: *pieceLength,
+	}
+	if !*noDate {
+		b.CreationDate = time.Now()
+	}
+
+	for _, tier := range trackers {
+		b.AnnounceList = append(b.AnnounceList, strings.Split(tier, ","))
+	}
+	if len(b.AnnounceList) == 1 && len(b.AnnounceList[0]) == 1 {
+		// A single tracker needs no announce list
+		b.Announce, b.AnnounceList = b.AnnounceList[0][0], nil
+	}
+
+	mf, err := b.Build(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to create torrent: %v", err)
+	}
+
+	if err := writeMetaFile(*outFile, mf); err != nil {
+		return err
+	}
+
+	fmt.Printf("Torrent created: %v\n", *outFile)
+	fmt.Printf("Info Hash: %x\n", mf.Info.Hash)
+
+	return nil
+}
+
+// writeMetaFile writes the torrent to a .torrent file.
+func writeMetaFile(outFile string, mf *metainfo.MetaFile) error {
+	data, err := mf.Bencode()
+	if err != nil {
+		return fmt.Errorf("failed to encode torrent: %v", err)
+	}
+
+	return util.WriteToOut(outFile, data)
+}
+
 func infoCommand() error {
 	filename := os.Args[2]
 
@@ -492,13 +794,76 @@ func infoCommand() error {
 		return err
 	}
 
-	fmt.Printf("Tracker URL: %v\n", mf.Announce)
+	printMetaFile(mf)
+
+	return nil
+}
+
+// printMetaFile prints the torrent information, including
+// the list of files for multi-file torrents.
+func printMetaFile(mf *metainfo.MetaFile) {
+	if mf.Announce != "" {
+		fmt.Printf("Tracker URL: %v\n", mf.Announce)
+	}
 	fmt.Printf("Length: %v\n", mf.Info.Length)
 	fmt.Printf("Info Hash: %x\n", mf.Info.Hash)
+	if mf.Info.IsV2() {
+		fmt.Printf("Info Hash v2: %x\n", mf.Info.HashV2)
+	}
 	fmt.Printf("Piece Length: %v\n", mf.Info.PieceLength)
-	fmt.Printf("Piece Hashes:\n%v\n", strings.Join(mf.Info.PieceHashes, "\n"))
+	if len(mf.Info.PieceHashes) > 0 {
+		fmt.Printf("Piece Hashes:\n%v\n", strings.Join(mf.Info.PieceHashes, "\n"))
+	}
 
-	return nil
+	if mf.Comment != "" {
+		fmt.Printf("Comment: %v\n", mf.Comment)
+	}
+	if mf.CreatedBy != "" {
+		fmt.Printf("Created By: %v\n", mf.CreatedBy)
+	}
+	if mf.CreationDate != 0 {
+		fmt.Printf("Creation Date: %v\n", mf.CreationTime().UTC().Format(time.RFC3339))
+	}
+	if mf.Info.Private {
+		fmt.Printf("Private: yes\n")
+	}
+	if mf.Info.Source != "" {
+		fmt.Printf("Source: %v\n", mf.Info.Source)
+	}
+
+	if len(mf.AnnounceList) > 0 {
+		fmt.Printf("Tracker Tiers:\n")
+
+		for i, tier := range mf.AnnounceList {
+			fmt.Printf("%4d  %v\n", i, strings.Join(tier, " "))
+		}
+	}
+
+	if len(mf.Info.Files) > 0 {
+		fmt.Printf("Files:\n")
+
+		for _, f := range mf.Info.Files {
+			fmt.Printf("%12d  %v%v\n", f.Length, f.PathString(), fileAttrString(f))
+		}
+	} else if mf.Info.IsV2() {
+		fmt.Printf("Files:\n")
+
+		for _, f := range mf.Info.TreeFiles {
+			fmt.Printf("%12d  %v\n", f.Length, f.PathString())
+		}
+	}
+}
+
+// fileAttrString describes the attributes of the file for printMetaFile.
+func fileAttrString(f metainfo.FileInfo) (s string) {
+	if f.Attr != "" {
+		s = fmt.Sprintf("  [%v]", f.Attr)
+	}
+	if f.IsSymlink() {
+		s += " -> " + strings.Join(f.SymlinkPath, "/")
+	}
+
+	return
 }
 
 func peersCommand() error {
@@ -509,7 +874,7 @@ func peersCommand() error {
 		return fmt.Errorf("failed to parse metafile: %v", err)
 	}
 
-	peersInfo, err := peer.DiscoverPeers(mf.Announce, mf.Info.Hash, mf.Info.Length)
+	peersInfo, err := tracker.DiscoverPeers(mf.Trackers(), mf.Info.Hash, mf.Info.Length)
 	if err != nil {
 		return err
 	}
@@ -523,3 +888,214 @@ func peersCommand() error {
 
 	return nil
 }
+
+// scrapeTarget is a torrent whose trackers are scraped.
+type scrapeTarget struct {
+	name     string
+	infoHash string
+	trackers []string
+}
+
+// scrapeRow holds the stats of the swarm of a torrent at a tracker.
+type scrapeRow struct {
+	Name      string `json:"name"`
+	InfoHash  string `json:"info_hash"`
+	Tracker   string `json:"tracker"`
+	Seeders   int    `json:"seeders"`
+	Leechers  int    `json:"leechers"`
+	Completed int    `json:"completed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// scrapeCommand prints the stats of the swarms of torrents, as given by
+// their trackers, without joining the swarms. Each tracker is scraped once
+// for all the torrents it tracks.
+func scrapeCommand() error {
+	const usage = "mybittorrent scrape [--json] <torrent_file|magnet_link>..."
+
+	flags := flag.NewFlagSet("scrape", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the results as JSON")
+
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 1 {
+		return fmt.Errorf("not enough arguments: expected '%s'", usage)
+	}
+
+	var (
+		targets   []scrapeTarget
+		announces []string
+		hashes    = make(map[string][]string)
+	)
+
+	for _, arg := range flags.Args() {
+		target, err := newScrapeTarget(arg)
+		if err != nil {
+			return err
+		}
+
+		targets = append(targets, target)
+
+		for _, announce := range target.trackers {
+			if _, ok := hashes[announce]; !ok {
+				announces = append(announces, announce)
+			}
+			if !slices.Contains(hashes[announce], target.infoHash) {
+				hashes[announce] = append(hashes[announce], target.infoHash)
+			}
+		}
+	}
+
+	results := make(map[string]map[string]tracker.ScrapeResult, len(announces))
+	errs := make(map[string]error)
+
+	for _, announce := range announces {
+		results[announce], errs[announce] = tracker.Scrape(announce, hashes[announce]...)
+	}
+
+	var rows []scrapeRow
+
+	for _, target := range targets {
+		for _, announce := range target.trackers {
+			row := scrapeRow{
+				Name:     target.name,
+				InfoHash: hex.EncodeToString([]byte(target.infoHash)),
+				Tracker:  announce,
+			}
+
+			if res, ok := results[announce][target.infoHash]; ok {
+				row.Seeders, row.Leechers, row.Completed = res.Seeders, res.Leechers, res.Completed
+			} else if err := errs[announce]; err != nil {
+				row.Error = err.Error()
+			} else {
+				row.Error = "torrent not tracked"
+			}
+
+			rows = append(rows, row)
+		}
+	}
+
+	if *asJSON {
+		jsonOutput, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %v", err)
+		}
+
+		fmt.Println(string(jsonOutput))
+
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tINFO HASH\tTRACKER\tSEEDERS\tLEECHERS\tCOMPLETED")
+
+	for _, row := range rows {
+		if row.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\t-\terror: %s\n", row.Name, row.InfoHash, row.Tracker, row.Error)
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\n", row.Name, row.InfoHash, row.Tracker, row.Seeders, row.Leechers, row.Completed)
+	}
+
+	return w.Flush()
+}
+
+func trackerCommand() error {
+	const usage = "mybittorrent tracker [--listen <addr>] [--udp] [--interval <duration>] [--allow <info_hash|torrent_file>]..."
+
+	var allowed stringList
+
+	flags := flag.NewFlagSet("tracker", flag.ContinueOnError)
+	listen := flags.String("listen", ":6969", "address to serve announces and scrapes on")
+	udp := flags.Bool("udp", false, "also serve UDP trackers (BEP 15) on the same address")
+	interval := flags.Duration("interval", tracker.DefaultServerInterval, "announce interval given to peers")
+	flags.Var(&allowed, "allow", "info hash in hex or torrent file allowed on the tracker, can be repeated (default: any torrent)")
+
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 0 || *interval < time.Second {
+		return fmt.Errorf("invalid arguments: expected '%s'", usage)
+	}
+
+	srv := tracker.NewServer()
+	srv.Interval = *interval
+	srv.PeerTTL = 2 * *interval
+
+	if len(allowed) > 0 {
+		srv.Allowlist = make(map[string]bool, len(allowed))
+
+		for _, arg := range allowed {
+			infoHash, err := allowedInfoHash(arg)
+			if err != nil {
+				return err
+			}
+
+			srv.Allowlist[infoHash] = true
+		}
+	}
+
+	fmt.Printf("Tracker listening on %v (HTTP", *listen)
+	if *udp {
+		fmt.Print(" and UDP")
+	}
+	fmt.Println(")")
+
+	return srv.ListenAndServe(*listen, *udp)
+}
+
+// allowedInfoHash reads the info hash of an allowlist entry,
+// either a hex info hash or a .torrent file.
+func allowedInfoHash(arg string) (string, error) {
+	if infoHash, err := hex.DecodeString(arg); err == nil && len(infoHash) == 20 {
+		return string(infoHash), nil
+	}
+
+	mf, err := metainfo.ParseMetaFile(arg)
+	if err != nil {
+		return "", fmt.Errorf("invalid allowed torrent %q: %v", arg, err)
+	}
+
+	return mf.Info.Hash, nil
+}
+
+// newScrapeTarget reads the info hash and trackers of a torrent
+// from a magnet link or a .torrent file.
+func newScrapeTarget(arg string) (scrapeTarget, error) {
+	if strings.HasPrefix(arg, "magnet:") {
+		m, err := magnet.Parse(arg)
+		if err != nil {
+			return scrapeTarget{}, fmt.Errorf("failed to parse magnet link: %v", err)
+		}
+
+		if len(m.Trackers) == 0 {
+			return scrapeTarget{}, fmt.Errorf("no trackers in magnet link: %v", arg)
+		}
+
+		return scrapeTarget{m.DisplayName, m.Hash(), m.Trackers}, nil
+	}
+
+	mf, err := metainfo.ParseMetaFile(arg)
+	if err != nil {
+		return scrapeTarget{}, fmt.Errorf("failed to parse metafile: %v", err)
+	}
+
+	var trackers []string
+	for _, tier := range mf.Trackers() {
+		for _, announce := range tier {
+			if !slices.Contains(trackers, announce) {
+				trackers = append(trackers, announce)
+			}
+		}
+	}
+
+	if len(trackers) == 0 {
+		return scrapeTarget{}, fmt.Errorf("no trackers in torrent file: %v", arg)
+	}
+
+	return scrapeTarget{mf.Info.Name, mf.Info.Hash, trackers}, nil
+}
@@ -1,54 +1,274 @@
 package magnet
 
 import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
 	"encoding/hex"
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/metainfo"
+)
+
+const (
+	btihPrefix = "urn:btih:"
+	btmhPrefix = "urn:btmh:"
+	// sha256MultihashPrefix is the multihash code and length of a
+	// SHA256 digest, which v2 info hashes are encoded as in btmh
+	sha256MultihashPrefix = "1220"
 )
 
-// ParseMagnetLink parses the magnet link and returns the info hash(binary encoded), filename and tracker URL.
-// The magnet link should be in the v1 magnet format: "magnet:?xt=urn:btih:<info_hash>&dn=<filename>&tr=<tracker_url>"
-func ParseMagnetLink(magnetLink string) (infoHash, filename, trackerURL string, err error) {
-	if !strings.HasPrefix(magnetLink, "magnet:?") {
-		err = fmt.Errorf("invalid magnet link: %v", magnetLink)
-		return
+// FileRange is an inclusive range of file indices selected with so (BEP 53).
+type FileRange struct {
+	First, Last int
+}
+
+// Magnet is a parsed magnet link (BEP 9). It holds a v1 info hash,
+// a v2 info hash, or both for hybrid torrents.
+type Magnet struct {
+	// InfoHash is the binary SHA1 info hash of v1 torrents (xt=urn:btih)
+	InfoHash string
+	// InfoHashV2 is the binary SHA256 info hash of v2 torrents (xt=urn:btmh)
+	InfoHashV2 string
+	// DisplayName is the suggested name of the torrent (dn)
+	DisplayName string
+	// Trackers are the tracker URLs (tr)
+	Trackers []string
+	// Peers are the addresses of peers, as host:port (x.pe)
+	Peers []string
+	// WebSeeds are the web seed URLs (ws)
+	WebSeeds []string
+	// Length is the total length of the content, 0 if unknown (xl)
+	Length int64
+	// SelectOnly holds the indices of the files to download,
+	// all files if empty (so)
+	SelectOnly []FileRange
+}
+
+// Parse parses a magnet link, such as
+// "magnet:?xt=urn:btih:<info_hash>&dn=<name>&tr=<tracker_url>".
+// The info hash may be hex or base32 encoded. Only the info hash is required.
+func Parse(link string) (*Magnet, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %v", err)
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("invalid magnet link: %v", link)
+	}
+
+	params, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %v", err)
 	}
 
-	magnetLink = strings.TrimPrefix(magnetLink, "magnet:?")
+	m := &Magnet{
+		DisplayName: params.Get("dn"),
+		Trackers:    nonEmpty(params["tr"]),
+		Peers:       nonEmpty(params["x.pe"]),
+		WebSeeds:    nonEmpty(params["ws"]),
+	}
+
+	for _, xt := range params["xt"] {
+		switch {
+		case strings.HasPrefix(xt, btihPrefix):
+			m.InfoHash, err = parseBTIH(strings.TrimPrefix(xt, btihPrefix))
+		case strings.HasPrefix(xt, btmhPrefix):
+			m.InfoHashV2, err = parseBTMH(strings.TrimPrefix(xt, btmhPrefix))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	parts := strings.Split(magnetLink, "&")
+	if m.InfoHash == "" && m.InfoHashV2 == "" {
+		return nil, fmt.Errorf("invalid magnet link: missing info hash")
+	}
 
-	for _, part := range parts {
-		if strings.HasPrefix(part, "xt=urn:btih:") {
-			infoHash = strings.TrimPrefix(part, "xt=urn:btih:")
-		} else if strings.HasPrefix(part, "dn=") {
-			filename = strings.TrimPrefix(part, "dn=")
-		} else if strings.HasPrefix(part, "tr=") {
-			trackerURL = strings.TrimPrefix(part, "tr=")
+	if xl := params.Get("xl"); xl != "" {
+		if m.Length, err = strconv.ParseInt(xl, 10, 64); err != nil || m.Length < 0 {
+			return nil, fmt.Errorf("invalid exact length: %v", xl)
 		}
 	}
 
-	if infoHash == "" || filename == "" || trackerURL == "" {
-		err = fmt.Errorf("invalid magnet link: %v", magnetLink)
-		return
+	if so := params.Get("so"); so != "" {
+		if m.SelectOnly, err = parseSelectOnly(so); err != nil {
+			return nil, err
+		}
 	}
 
-	// Unescape the tracker URL
-	trackerURL, err = url.QueryUnescape(trackerURL)
+	return m, nil
+}
+
+// parseBTIH decodes a v1 info hash, either 40 hex or 32 base32 characters.
+func parseBTIH(s string) (string, error) {
+	var (
+		b   []byte
+		err error
+	)
+
+	switch len(s) {
+	case 2 * sha1.Size:
+		b, err = hex.DecodeString(s)
+	case base32.StdEncoding.EncodedLen(sha1.Size):
+		b, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		err = fmt.Errorf("unexpected length %d", len(s))
+	}
 	if err != nil {
-		err = fmt.Errorf("failed to unescape tracker URL: %v", err)
-		return
+		return "", fmt.Errorf("failed to decode info hash: %v", err)
+	}
+
+	return string(b), nil
+}
+
+// parseBTMH decodes a v2 info hash, given as a hex encoded SHA256 multihash.
+func parseBTMH(s string) (string, error) {
+	if !strings.HasPrefix(s, sha256MultihashPrefix) || len(s) != len(sha256MultihashPrefix)+2*sha256.Size {
+		return "", fmt.Errorf("failed to decode v2 info hash: not a SHA256 multihash")
 	}
 
-	// Convert the info hash from hex to binary
-	infoHashHex, err := hex.DecodeString(infoHash)
+	b, err := hex.DecodeString(strings.TrimPrefix(s, sha256MultihashPrefix))
 	if err != nil {
-		err = fmt.Errorf("failed to decode info hash: %v", err)
-		return
+		return "", fmt.Errorf("failed to decode v2 info hash: %v", err)
 	}
 
-	infoHash = string(infoHashHex)
+	return string(b), nil
+}
+
+// parseSelectOnly parses a list of file indices and
+// inclusive ranges of them, such as "0,2,4-6".
+func parseSelectOnly(s string) ([]FileRange, error) {
+	var ranges []FileRange
+
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		if !isRange {
+			last = first
+		}
+
+		r := FileRange{}
+		var err1, err2 error
+		r.First, err1 = strconv.Atoi(first)
+		r.Last, err2 = strconv.Atoi(last)
+
+		if err1 != nil || err2 != nil || r.First < 0 || r.Last < r.First {
+			return nil, fmt.Errorf("invalid file selection: %v", s)
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges, nil
+}
+
+func nonEmpty(values []string) []string {
+	var res []string
+	for _, v := range values {
+		if v != "" {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+// Hash returns the info hash used with peers and trackers: the v1 info
+// hash, or the truncated v2 info hash of v2-only torrents.
+func (m *Magnet) Hash() string {
+	if m.InfoHash != "" {
+		return m.InfoHash
+	}
+
+	return m.InfoHashV2[:sha1.Size]
+}
+
+// Selected reports whether the file with the given index is to be
+// downloaded.
+func (m *Magnet) Selected(fileIdx int) bool {
+	if len(m.SelectOnly) == 0 {
+		return true
+	}
+
+	for _, r := range m.SelectOnly {
+		if r.First <= fileIdx && fileIdx <= r.Last {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FromMetaFile returns the magnet link of the torrent.
+func FromMetaFile(mf *metainfo.MetaFile) *Magnet {
+	m := &Magnet{
+		DisplayName: mf.Info.Name,
+		WebSeeds:    mf.URLList,
+		Length:      mf.Info.Length,
+	}
+
+	if mf.Info.Pieces != "" {
+		m.InfoHash = mf.Info.Hash
+	}
+	if mf.Info.IsV2() {
+		m.InfoHashV2 = mf.Info.HashV2
+	}
+
+	seen := make(map[string]bool)
+	for _, tier := range mf.Trackers() {
+		for _, tracker := range tier {
+			if !seen[tracker] {
+				seen[tracker] = true
+				m.Trackers = append(m.Trackers, tracker)
+			}
+		}
+	}
+
+	return m
+}
+
+// String returns the magnet link.
+func (m *Magnet) String() string {
+	var params []string
+
+	add := func(key, value string) {
+		params = append(params, key+"="+url.QueryEscape(value))
+	}
+
+	if m.InfoHash != "" {
+		params = append(params, "xt="+btihPrefix+hex.EncodeToString([]byte(m.InfoHash)))
+	}
+	if m.InfoHashV2 != "" {
+		params = append(params, "xt="+btmhPrefix+sha256MultihashPrefix+hex.EncodeToString([]byte(m.InfoHashV2)))
+	}
+	if m.DisplayName != "" {
+		add("dn", m.DisplayName)
+	}
+	if m.Length > 0 {
+		add("xl", strconv.FormatInt(m.Length, 10))
+	}
+	for _, tr := range m.Trackers {
+		add("tr", tr)
+	}
+	for _, ws := range m.WebSeeds {
+		add("ws", ws)
+	}
+	for _, pe := range m.Peers {
+		add("x.pe", pe)
+	}
+	if len(m.SelectOnly) > 0 {
+		ranges := make([]string, len(m.SelectOnly))
+		for i, r := range m.SelectOnly {
+			ranges[i] = strconv.Itoa(r.First)
+			if r.Last != r.First {
+				ranges[i] += "-" + strconv.Itoa(r.Last)
+			}
+		}
+		params = append(params, "so="+strings.Join(ranges, ","))
+	}
 
-	return
+	return "magnet:?" + strings.Join(params, "&")
 }
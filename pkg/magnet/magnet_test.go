@@ -0,0 +1,170 @@
+package magnet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/metainfo"
+)
+
+const (
+	testHashHex    = "d69f91e6b2ae4c542468d1073a71d4ea13879a7f"
+	testHashBase32 = "22PZDZVSVZGFIJDI2EDTU4OU5IJYPGT7"
+	testHashV2Hex  = "8d3c9a4b1e2f70615243a5b6c7d8e9f00112233445566778899aabbccddeeff0"
+)
+
+func unhex(s string) string {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return string(b)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		want *Magnet
+		err  string
+	}{
+		{
+			name: "hex btih",
+			link: "magnet:?xt=urn:btih:" + testHashHex + "&dn=sample.txt",
+			want: &Magnet{InfoHash: unhex(testHashHex), DisplayName: "sample.txt"},
+		},
+		{
+			name: "base32 btih",
+			link: "magnet:?xt=urn:btih:" + testHashBase32,
+			want: &Magnet{InfoHash: unhex(testHashHex)},
+		},
+		{
+			name: "lowercase base32 btih",
+			link: "magnet:?xt=urn:btih:" + strings.ToLower(testHashBase32),
+			want: &Magnet{InfoHash: unhex(testHashHex)},
+		},
+		{
+			name: "btmh",
+			link: "magnet:?xt=urn:btmh:1220" + testHashV2Hex,
+			want: &Magnet{InfoHashV2: unhex(testHashV2Hex)},
+		},
+		{
+			name: "hybrid",
+			link: "magnet:?xt=urn:btih:" + testHashHex + "&xt=urn:btmh:1220" + testHashV2Hex,
+			want: &Magnet{InfoHash: unhex(testHashHex), InfoHashV2: unhex(testHashV2Hex)},
+		},
+		{
+			name: "repeated tr",
+			link: "magnet:?xt=urn:btih:" + testHashHex + "&tr=http%3A%2F%2Fa.example%2Fannounce&tr=&tr=udp%3A%2F%2Fb.example%3A6969",
+			want: &Magnet{InfoHash: unhex(testHashHex), Trackers: []string{"http://a.example/announce", "udp://b.example:6969"}},
+		},
+		{
+			name: "x.pe",
+			link: "magnet:?xt=urn:btih:" + testHashHex + "&x.pe=127.0.0.1%3A6881&x.pe=%5B%3A%3A1%5D%3A6882",
+			want: &Magnet{InfoHash: unhex(testHashHex), Peers: []string{"127.0.0.1:6881", "[::1]:6882"}},
+		},
+		{
+			name: "so ranges",
+			link: "magnet:?xt=urn:btih:" + testHashHex + "&so=0,2,4-6&xl=1024",
+			want: &Magnet{InfoHash: unhex(testHashHex), Length: 1024, SelectOnly: []FileRange{{0, 0}, {2, 2}, {4, 6}}},
+		},
+		{name: "not a magnet", link: "http://example.com/?xt=urn:btih:" + testHashHex, err: "invalid magnet link"},
+		{name: "missing info hash", link: "magnet:?dn=sample.txt", err: "missing info hash"},
+		{name: "short btih", link: "magnet:?xt=urn:btih:" + testHashHex[:38], err: "failed to decode info hash"},
+		{name: "invalid hex btih", link: "magnet:?xt=urn:btih:" + strings.Repeat("z", 40), err: "failed to decode info hash"},
+		{name: "btmh not sha256", link: "magnet:?xt=urn:btmh:1320" + testHashV2Hex, err: "not a SHA256 multihash"},
+		{name: "reversed so range", link: "magnet:?xt=urn:btih:" + testHashHex + "&so=6-4", err: "invalid file selection"},
+		{name: "negative so", link: "magnet:?xt=urn:btih:" + testHashHex + "&so=-1", err: "invalid file selection"},
+		{name: "negative xl", link: "magnet:?xt=urn:btih:" + testHashHex + "&xl=-1", err: "invalid exact length"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Parse(tt.link)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("Parse error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			if !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("Parse = %+v, want %+v", m, tt.want)
+			}
+		})
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	m := &Magnet{
+		InfoHash:    unhex(testHashHex),
+		InfoHashV2:  unhex(testHashV2Hex),
+		DisplayName: "a name & more",
+		Trackers:    []string{"http://a.example/announce?key=1&x=2", "udp://b.example:6969"},
+		Peers:       []string{"127.0.0.1:6881", "[::1]:6882"},
+		WebSeeds:    []string{"https://c.example/files/"},
+		Length:      1 << 40,
+		SelectOnly:  []FileRange{{0, 0}, {3, 5}},
+	}
+
+	got, err := Parse(m.String())
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", m.String(), err)
+	}
+
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("Parse(%q) = %+v, want %+v", m.String(), got, m)
+	}
+}
+
+func TestFromMetaFileRoundTrip(t *testing.T) {
+	data, err := bencode.Marshal(map[string]any{
+		"announce":      "http://a.example/announce",
+		"announce-list": []any{[]any{"http://a.example/announce"}, []any{"udp://b.example:6969", "http://a.example/announce"}},
+		"url-list":      "https://c.example/files/",
+		"info": map[string]any{
+			"name":         "sample.txt",
+			"length":       100,
+			"piece length": 64,
+			"pieces":       strings.Repeat("x", 2*20),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	mf, err := metainfo.ReadMetaFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadMetaFile: %v", err)
+	}
+
+	want := &Magnet{
+		InfoHash:    mf.Info.Hash,
+		DisplayName: "sample.txt",
+		Trackers:    []string{"http://a.example/announce", "udp://b.example:6969"},
+		WebSeeds:    []string{"https://c.example/files/"},
+		Length:      100,
+	}
+
+	link := FromMetaFile(mf).String()
+
+	got, err := Parse(link)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", link, err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse(%q) = %+v, want %+v", link, got, want)
+	}
+	if got.Hash() != mf.Info.Hash {
+		t.Errorf("Hash = %x, want %x", got.Hash(), mf.Info.Hash)
+	}
+}
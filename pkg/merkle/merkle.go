@@ -0,0 +1,163 @@
+// Package merkle implements the SHA-256 merkle trees of BitTorrent v2
+// torrents (BEP 52). Each file has its own tree, whose leaves are the hashes
+// of 16 KiB blocks of the file. The leaf layer is padded with zero hashes
+// to a power of two, and the root of the tree is the pieces root of the file.
+package merkle
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+const (
+	// BlockSize is the size of the data hashed into a leaf
+	BlockSize = 16 << 10 // 16KB
+	// HashSize is the size of a node of the tree
+	HashSize = sha256.Size
+)
+
+// Hash is a node of a merkle tree.
+type Hash [HashSize]byte
+
+// HashFromBytes converts b to a Hash, failing if b has the wrong length.
+func HashFromBytes(b []byte) (h Hash, err error) {
+	if len(b) != HashSize {
+		return h, fmt.Errorf("invalid hash length: %d", len(b))
+	}
+
+	copy(h[:], b)
+
+	return h, nil
+}
+
+// HashesFromBytes splits b into hashes.
+func HashesFromBytes(b []byte) ([]Hash, error) {
+	if len(b)%HashSize != 0 {
+		return nil, fmt.Errorf("invalid hashes length: %d", len(b))
+	}
+
+	hashes := make([]Hash, len(b)/HashSize)
+	for i := range hashes {
+		copy(hashes[i][:], b[i*HashSize:])
+	}
+
+	return hashes, nil
+}
+
+// HashBlock returns the leaf hash of a block of at most BlockSize bytes.
+func HashBlock(block []byte) Hash {
+	return sha256.Sum256(block)
+}
+
+// HashPair returns the parent of two nodes.
+func HashPair(left, right Hash) Hash {
+	var buf [2 * HashSize]byte
+	copy(buf[:], left[:])
+	copy(buf[HashSize:], right[:])
+
+	return sha256.Sum256(buf[:])
+}
+
+// PadHash returns the root of a subtree with 2^height zero leaves,
+// which stands in for nodes past the end of a file.
+func PadHash(height int) Hash {
+	var h Hash
+	for i := 0; i < height; i++ {
+		h = HashPair(h, h)
+	}
+
+	return h
+}
+
+// Root returns the root of the tree whose layer at the given height is
+// nodes. The layer is padded to a power of two with PadHash(height).
+func Root(nodes []Hash, height int) Hash {
+	if len(nodes) == 0 {
+		return Hash{}
+	}
+
+	layer := make([]Hash, NextPowerOfTwo(len(nodes)))
+	copy(layer, nodes)
+
+	pad := PadHash(height)
+	for i := len(nodes); i < len(layer); i++ {
+		layer[i] = pad
+	}
+
+	for len(layer) > 1 {
+		for i := 0; i < len(layer)/2; i++ {
+			layer[i] = HashPair(layer[2*i], layer[2*i+1])
+		}
+		layer = layer[:len(layer)/2]
+	}
+
+	return layer[0]
+}
+
+// BlockHashes returns the leaf hashes of data.
+func BlockHashes(data []byte) []Hash {
+	hashes := make([]Hash, 0, (len(data)+BlockSize-1)/BlockSize)
+
+	for len(data) > 0 {
+		n := min(len(data), BlockSize)
+		hashes = append(hashes, HashBlock(data[:n]))
+		data = data[n:]
+	}
+
+	return hashes
+}
+
+// DataRoot returns the root of the subtree over data with numLeaves
+// leaves, which must be a power of two. Leaves past the end of the data
+// are zero hashes. The root of a piece has pieceLength/BlockSize leaves,
+// the root of a file that fits in one piece has as many leaves as blocks,
+// rounded up to a power of two.
+func DataRoot(data []byte, numLeaves int) Hash {
+	leaves := BlockHashes(data)
+	if len(leaves) == 0 {
+		return Hash{}
+	}
+
+	// Zero leaves are the same as padding the layer with PadHash(0)
+	layer := make([]Hash, numLeaves)
+	copy(layer, leaves)
+
+	return Root(layer, 0)
+}
+
+// Height returns the height of the subtree covering n leaves,
+// n being a power of two.
+func Height(n int) int {
+	height := 0
+	for ; n > 1; n >>= 1 {
+		height++
+	}
+
+	return height
+}
+
+// NextPowerOfTwo returns the smallest power of two not less than n.
+func NextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+
+	return p
+}
+
+// VerifyProof reports whether node, at position index of its layer, adds
+// up to root with the given uncle hashes. The uncles are the siblings of
+// node and of its ancestors, starting from the sibling of node.
+func VerifyProof(root, node Hash, index int, uncles []Hash) bool {
+	for _, uncle := range uncles {
+		if index%2 == 0 {
+			node = HashPair(node, uncle)
+		} else {
+			node = HashPair(uncle, node)
+		}
+		index /= 2
+	}
+
+	return index == 0 && node == root
+}
@@ -0,0 +1,134 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// testData returns n bytes of a fixed pattern.
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	return data
+}
+
+func mustHash(t *testing.T, s string) Hash {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+
+	h, err := HashFromBytes(b)
+	if err != nil {
+		t.Fatalf("HashFromBytes: %v", err)
+	}
+
+	return h
+}
+
+func TestPadHash(t *testing.T) {
+	tests := []struct {
+		height int
+		want   string
+	}{
+		{0, "0000000000000000000000000000000000000000000000000000000000000000"},
+		{1, "f5a5fd42d16a20302798ef6ed309979b43003d2320d9f0e8ea9831a92759fb4b"},
+		{2, "db56114e00fdd4c1f85c892bf35ac9a89289aaecb1ebd0a96cde606a748b5d71"},
+	}
+
+	for _, tt := range tests {
+		if got := PadHash(tt.height); got != mustHash(t, tt.want) {
+			t.Errorf("PadHash(%d) = %x, want %s", tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestDataRoot(t *testing.T) {
+	tests := []struct {
+		name      string
+		length    int
+		numLeaves int
+		want      string
+	}{
+		{"empty", 0, 1, "0000000000000000000000000000000000000000000000000000000000000000"},
+		{"single short block", 100, 1, "bce0aff19cf5aa6a7469a30d61d04e4376e4bbf6381052ee9e7f33925c954d52"},
+		{"last block short", BlockSize + 100, 2, "9736c9e81a78092a146406419b482c8e184f7bf9b6d6d4d34bf15b4832fb1821"},
+		{"zero leaves", BlockSize + 100, 4, "8af3ca24c7221de3d8d3283b89796bd6b52fc98b14139c152a3d1cc11a92588c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DataRoot(testData(tt.length), tt.numLeaves); got != mustHash(t, tt.want) {
+				t.Errorf("DataRoot = %x, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoot(t *testing.T) {
+	nodes := []Hash{sha256.Sum256([]byte("a")), sha256.Sum256([]byte("b")), sha256.Sum256([]byte("c"))}
+
+	tests := []struct {
+		name   string
+		nodes  []Hash
+		height int
+		want   string
+	}{
+		{"none", nil, 0, "0000000000000000000000000000000000000000000000000000000000000000"},
+		{"single", nodes[:1], 3, hex.EncodeToString(nodes[0][:])},
+		{"padded leaves", nodes, 0, "d0a664079d491a97357efa1ce1eab5aeb566adef78a2b910e8d13e901e192832"},
+		{"padded pieces", nodes, 1, "e09ad3a0433ab084ba5629b98d282fc3667efd24ddcf01d3f279a4d05f53a13d"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Root(tt.nodes, tt.height); got != mustHash(t, tt.want) {
+				t.Errorf("Root = %x, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyProof(t *testing.T) {
+	leaves := BlockHashes(testData(3*BlockSize + 100))
+	leaves = append(leaves, Hash{})
+
+	left := HashPair(leaves[0], leaves[1])
+	right := HashPair(leaves[2], leaves[3])
+	root := HashPair(left, right)
+
+	if got := DataRoot(testData(3*BlockSize+100), 4); got != root {
+		t.Fatalf("DataRoot = %x, want %x", got, root)
+	}
+
+	tests := []struct {
+		name   string
+		node   Hash
+		index  int
+		uncles []Hash
+		want   bool
+	}{
+		{"first leaf", leaves[0], 0, []Hash{leaves[1], right}, true},
+		{"short last leaf", leaves[3], 3, []Hash{leaves[2], left}, true},
+		{"piece", right, 1, []Hash{left}, true},
+		{"root", root, 0, nil, true},
+		{"wrong index", leaves[0], 1, []Hash{leaves[1], right}, false},
+		{"index out of the tree", leaves[0], 4, []Hash{leaves[1], right}, false},
+		{"wrong uncle", leaves[0], 0, []Hash{leaves[2], right}, false},
+		{"missing uncle", leaves[0], 0, []Hash{leaves[1]}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyProof(root, tt.node, tt.index, tt.uncles); got != tt.want {
+				t.Errorf("VerifyProof = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
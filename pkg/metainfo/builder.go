@@ -0,0 +1,295 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Bounds of the piece length picked by the Builder, and the number
+// of pieces it aims for.
+const (
+	MinPieceLength   = 16 << 10
+	MaxPieceLength   = 16 << 20
+	targetPieceCount = 1500
+)
+
+// DefaultCreatedBy is the "created by" value of torrents made by this client.
+const DefaultCreatedBy = "mybittorrent"
+
+const hashReadBufferLen = 1 << 20
+
+// Builder creates .torrent files from a file or a directory.
+// Fields left empty are omitted from the resulting torrent.
+type Builder struct {
+	// Name of the torrent, defaults to the base name of the path
+	Name         string
+	Announce     string
+	AnnounceList [][]string
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	Private      bool
+	Source       string
+	// WebSeeds are HTTP/FTP URLs serving the content (BEP 19)
+	WebSeeds []string
+	// PieceLength is picked from the total size of the content if zero
+	PieceLength int
+}
+
+// Build hashes the file or directory at path and returns the torrent, to
+// be written out with Bencode. Directories become multi-file torrents with
+// their regular files in lexical order.
+func (b *Builder) Build(path string) (*MetaFile, error) {
+	mf := &MetaFile{
+		Announce:     b.Announce,
+		AnnounceList: b.AnnounceList,
+		Comment:      b.Comment,
+		CreatedBy:    b.CreatedBy,
+		URLList:      b.WebSeeds,
+	}
+	if !b.CreationDate.IsZero() {
+		mf.CreationDate = b.CreationDate.Unix()
+	}
+
+	mf.normalizeTrackers()
+
+	name := b.Name
+	if name == "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		name = filepath.Base(abs)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info := MetaInfo{
+		Name:    name,
+		Private: b.Private,
+		Source:  b.Source,
+	}
+
+	var paths []string
+	if stat.IsDir() {
+		if paths, info.Files, err = listFiles(path); err != nil {
+			return nil, err
+		}
+		info.Length, err = totalLength(info.Files)
+	} else {
+		paths = []string{path}
+		info.Length = stat.Size()
+		info.Attr = fileAttr(stat.Mode())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Length == 0 {
+		return nil, fmt.Errorf("no data to hash in %v", path)
+	}
+
+	info.PieceLength = b.PieceLength
+	if info.PieceLength == 0 {
+		info.PieceLength = PieceLengthFor(info.Length)
+	}
+	if info.PieceLength < 0 {
+		return nil, fmt.Errorf("invalid piece length: %d", info.PieceLength)
+	}
+
+	if info.Pieces, err = hashPieces(paths, info.PieceLength, info.Length); err != nil {
+		return nil, err
+	}
+
+	// Encode the info dictionary once, fixing the info hash
+	rawInfo, err := info.Bencode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode info: %v", err)
+	}
+
+	parsed, err := NewMetaInfoFromBytes([]byte(rawInfo))
+	if err != nil {
+		return nil, err
+	}
+
+	mf.Info = *parsed
+
+	return mf, nil
+}
+
+// PieceLengthFor returns the piece length for content of the given size:
+// the power of two giving about 1500 pieces, between 16 KiB and 16 MiB.
+func PieceLengthFor(length int64) int {
+	pieceLength := MinPieceLength
+
+	for pieceLength < MaxPieceLength && length/int64(pieceLength) > targetPieceCount {
+		pieceLength *= 2
+	}
+
+	return pieceLength
+}
+
+// listFiles walks dir and returns the paths of its regular files in
+// lexical order, along with their torrent file entries. Executable files
+// get the executable attribute, and symlinks to files within dir are kept
+// as symlinks, while other symlinks are left out.
+func listFiles(dir string) (paths []string, files []FileInfo, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, ok := symlinkTarget(dir, path)
+			if ok {
+				files = append(files, FileInfo{
+					Attr:        string(AttrSymlink),
+					Path:        splitPath(rel),
+					SymlinkPath: splitPath(target),
+				})
+			}
+		case d.Type().IsRegular():
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			paths = append(paths, path)
+			files = append(files, FileInfo{
+				Attr:   fileAttr(fi.Mode()),
+				Length: fi.Size(),
+				Path:   splitPath(rel),
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list files: %v", err)
+	}
+
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("no files in %v", dir)
+	}
+
+	return paths, files, nil
+}
+
+// symlinkTarget returns the target of the symlink relative to dir,
+// if it's within dir.
+func symlinkTarget(dir, path string) (string, bool) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", false
+	}
+
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return rel, true
+}
+
+// fileAttr returns the attributes of a file with the given mode.
+func fileAttr(mode fs.FileMode) string {
+	if mode&0o111 != 0 {
+		return string(AttrExecutable)
+	}
+
+	return ""
+}
+
+func splitPath(path string) []string {
+	return strings.Split(filepath.ToSlash(path), "/")
+}
+
+// hashPieces reads the files one after another as a continuous stream and
+// returns the concatenated SHA1 hashes of its pieces.
+func hashPieces(paths []string, pieceLength int, length int64) (string, error) {
+	w := &pieceHasher{pieceLength: pieceLength, h: sha1.New()}
+	buf := make([]byte, hashReadBufferLen)
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = io.CopyBuffer(w, f, buf)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read %v: %v", path, err)
+		}
+	}
+
+	if w.total != length {
+		return "", fmt.Errorf("files changed while hashing: read %d bytes, expected %d", w.total, length)
+	}
+
+	return string(w.Sum()), nil
+}
+
+// pieceHasher hashes the data written to it in pieces of pieceLength.
+type pieceHasher struct {
+	pieceLength int
+	h           hash.Hash
+	n           int // bytes of the current piece written
+	total       int64
+	pieces      []byte
+}
+
+func (w *pieceHasher) Write(data []byte) (int, error) {
+	written := len(data)
+
+	for len(data) > 0 {
+		chunk := data
+		if remaining := w.pieceLength - w.n; len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+
+		w.h.Write(chunk)
+		w.n += len(chunk)
+		w.total += int64(len(chunk))
+
+		if w.n == w.pieceLength {
+			w.pieces = w.h.Sum(w.pieces)
+			w.h.Reset()
+			w.n = 0
+		}
+
+		data = data[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// Sum returns the hashes of all pieces, including the last partial one.
+func (w *pieceHasher) Sum() []byte {
+	if w.n > 0 {
+		w.pieces = w.h.Sum(w.pieces)
+		w.h.Reset()
+		w.n = 0
+	}
+
+	return w.pieces
+}
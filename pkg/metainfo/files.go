@@ -0,0 +1,130 @@
+package metainfo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// File attributes (BEP 47), each a character of the attr string.
+const (
+	AttrPadding    = 'p'
+	AttrExecutable = 'x'
+	AttrHidden     = 'h'
+	AttrSymlink    = 'l'
+)
+
+// FileInfo describes a file of a multi-file torrent.
+type FileInfo struct {
+	// Attr holds the attributes of the file, such as "x" for executables
+	Attr   string `bencode:"attr,omitempty"`
+	Length int64  `bencode:"length"`
+	// Path is the list of path components relative to the torrent
+	// directory, the last one being the file name.
+	Path []string `bencode:"path"`
+	// SymlinkPath is the path of the target of a symlink,
+	// relative to the torrent directory
+	SymlinkPath []string `bencode:"symlink path,omitempty"`
+}
+
+// PathString returns the path of the file joined with slashes.
+func (fi FileInfo) PathString() string {
+	return strings.Join(fi.Path, "/")
+}
+
+// IsPadding reports whether the file is padding, aligning the next file
+// to a piece boundary. Padding files are filled with zeros and aren't
+// meant to be written to disk.
+func (fi FileInfo) IsPadding() bool {
+	return strings.IndexByte(fi.Attr, AttrPadding) >= 0
+}
+
+// IsExecutable reports whether the file has the executable attribute.
+func (fi FileInfo) IsExecutable() bool {
+	return strings.IndexByte(fi.Attr, AttrExecutable) >= 0
+}
+
+// IsHidden reports whether the file has the hidden attribute.
+func (fi FileInfo) IsHidden() bool {
+	return strings.IndexByte(fi.Attr, AttrHidden) >= 0
+}
+
+// IsSymlink reports whether the file is a symlink to SymlinkPath.
+func (fi FileInfo) IsSymlink() bool {
+	return strings.IndexByte(fi.Attr, AttrSymlink) >= 0
+}
+
+// IsMultiFile reports whether the torrent has a list of files
+// rather than a single file. The file tree of single-file v2
+// torrents has one file, named after the torrent.
+func (mi *MetaInfo) IsMultiFile() bool {
+	if mi.isV2Only() {
+		return len(mi.TreeFiles) != 1 || mi.TreeFiles[0].PathString() != mi.Name
+	}
+
+	return len(mi.Files) > 0
+}
+
+// FileList returns the files of the torrent in the order they appear in
+// the pieces, with paths, and symlink paths, starting with the torrent name.
+// A single-file torrent has one file, named after the torrent. The files
+// of v2-only torrents are those of the file tree.
+func (mi *MetaInfo) FileList() []FileInfo {
+	if !mi.IsMultiFile() {
+		attr := mi.Attr
+		if mi.isV2Only() {
+			attr = mi.TreeFiles[0].Attr
+		}
+
+		return []FileInfo{{Attr: attr, Length: mi.Length, Path: []string{mi.Name}}}
+	}
+
+	v1Files := mi.Files
+	if mi.isV2Only() {
+		v1Files = make([]FileInfo, len(mi.TreeFiles))
+		for i, f := range mi.TreeFiles {
+			v1Files[i] = FileInfo{Attr: f.Attr, Length: f.Length, Path: f.Path, SymlinkPath: f.SymlinkPath}
+		}
+	}
+
+	files := make([]FileInfo, len(v1Files))
+
+	for i, f := range v1Files {
+		files[i] = f
+		files[i].Path = append([]string{mi.Name}, f.Path...)
+
+		if f.SymlinkPath != nil {
+			files[i].SymlinkPath = append([]string{mi.Name}, f.SymlinkPath...)
+		}
+	}
+
+	return files
+}
+
+// totalLength validates the files of a multi-file torrent
+// and returns the sum of their lengths.
+func totalLength(files []FileInfo) (total int64, err error) {
+	if len(files) == 0 {
+		return 0, fmt.Errorf("invalid files: empty list")
+	}
+
+	for i, f := range files {
+		if f.Length < 0 {
+			return 0, fmt.Errorf("invalid length of file %d: %d", i, f.Length)
+		}
+		if len(f.Path) == 0 {
+			return 0, fmt.Errorf("invalid path of file %d: empty", i)
+		}
+		for _, component := range f.Path {
+			if component == "" {
+				return 0, fmt.Errorf("invalid path of file %d: empty component in %q", i, f.PathString())
+			}
+		}
+		if f.IsSymlink() && len(f.SymlinkPath) == 0 {
+			return 0, fmt.Errorf("invalid symlink path of file %d: empty", i)
+		}
+
+		total += f.Length
+	}
+
+	return total, nil
+}
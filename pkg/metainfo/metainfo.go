@@ -1,59 +1,154 @@
 package metainfo
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/codecrafters-io/bittorrent-starter-go/pkg/bencode"
-	"github.com/codecrafters-io/bittorrent-starter-go/pkg/util"
 )
 
 // MetaInfo represents the metadata information of a torrent file.
+// Single-file torrents have Name as the file name and no Files, while
+// multi-file torrents have Name as the directory name and list the files
+// in it. In both cases Length is the total length of the content.
+//
+// v2 torrents (BEP 52) describe their files with a file tree instead,
+// and hybrid torrents have both the v1 and the v2 metadata.
 type MetaInfo struct {
-	Name        string
-	Pieces      string
-	Hash        string
-	PieceHashes []string
-	Length      int
-	PieceLength int
+	Name   string `bencode:"name"`
+	Pieces string `bencode:"pieces,omitempty"`
+	// Hash is the info hash used with peers and trackers: the SHA1 hash
+	// of the info dictionary, or the truncated v2 hash of v2-only torrents
+	Hash string `bencode:"-"`
+	// HashV2 is the SHA256 hash of the info dictionary of v2 torrents
+	HashV2      string     `bencode:"-"`
+	PieceHashes []string   `bencode:"-"`
+	Files       []FileInfo `bencode:"files,omitempty"`
+	Length      int64      `bencode:"length,omitempty"`
+	PieceLength int        `bencode:"piece length"`
+	// Private torrents only get peers from their trackers (BEP 27)
+	Private bool `bencode:"private,omitempty"`
+	// Source tags the torrent with where it was published,
+	// giving it a distinct info hash
+	Source string `bencode:"source,omitempty"`
+	// Attr holds the file attributes of single-file torrents (BEP 47)
+	Attr string `bencode:"attr,omitempty"`
+	// MetaVersion is 2 for v2 and hybrid torrents
+	MetaVersion int                `bencode:"meta version,omitempty"`
+	FileTree    bencode.RawMessage `bencode:"file tree,omitempty"`
+	// TreeFiles are the files of the file tree of v2 torrents
+	TreeFiles []TreeFile `bencode:"-"`
+
+	// raw is the info dictionary exactly as it was encoded,
+	// which the info hash is calculated from
+	raw bencode.RawMessage
+	// layout locates the files in the pieces, computed when parsed
+	layout *fileLayout
 }
 
 // NewMetaInfoFromMap creates a new MetaInfo instance from a map.
-func NewMetaInfoFromMap(m map[string]any) (mi *MetaInfo, err error) {
+// The map is re-encoded to calculate the info hash, so every key
+// of the original info dictionary should be present in it.
+func NewMetaInfoFromMap(m map[string]any) (*MetaInfo, error) {
+	raw, err := bencode.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode info: %v", err)
+	}
+
+	return NewMetaInfoFromBytes(raw)
+}
+
+// NewMetaInfoFromBytes creates a new MetaInfo instance from
+// a bencoded info dictionary. The info hash is the SHA1 hash
+// of the given bytes.
+func NewMetaInfoFromBytes(raw []byte) (mi *MetaInfo, err error) {
 	mi = new(MetaInfo)
 
-	if mi.Name, err = util.GetStringFromMap(m, "name"); err != nil {
-		return
+	if err = bencode.Unmarshal(raw, mi); err != nil {
+		return nil, fmt.Errorf("failed to decode info: %v", err)
 	}
-	if mi.Pieces, err = util.GetStringOrBytesFromMap(m, "pieces"); err != nil {
-		return
-	}
-	if mi.Length, err = util.GetIntFromMap(m, "length"); err != nil {
-		return
+
+	if mi.Name == "" {
+		return nil, fmt.Errorf("invalid name")
 	}
-	if mi.PieceLength, err = util.GetIntFromMap(m, "piece length"); err != nil {
-		return
+	if mi.PieceLength <= 0 {
+		return nil, fmt.Errorf("invalid piece length")
 	}
 
-	mi.PieceHashes = mi.pieceHashes()
-	mi.Hash, err = mi.Sha1Sum()
+	switch mi.MetaVersion {
+	case 0, 1:
+		err = mi.validateV1()
+	case MetaVersion2:
+		err = mi.validateV2()
+	default:
+		err = fmt.Errorf("unsupported meta version: %d", mi.MetaVersion)
+	}
 	if err != nil {
-		err = fmt.Errorf("failed to calculate info hash: %v", err)
 		return nil, err
 	}
 
+	mi.raw = append(bencode.RawMessage(nil), raw...)
+
+	mi.PieceHashes = mi.pieceHashes()
+	mi.layout = mi.newFileLayout()
+
+	if mi.IsV2() {
+		if mi.HashV2, err = mi.Sha256Sum(); err != nil {
+			return nil, fmt.Errorf("failed to calculate v2 info hash: %v", err)
+		}
+	}
+
+	if mi.Pieces == "" {
+		mi.Hash = mi.HashV2[:sha1.Size]
+	} else if mi.Hash, err = mi.Sha1Sum(); err != nil {
+		return nil, fmt.Errorf("failed to calculate info hash: %v", err)
+	}
+
 	return
 }
 
+// validateV1 checks the v1 parts of the info dictionary
+// and fills in the total length of multi-file torrents.
+func (mi *MetaInfo) validateV1() (err error) {
+	if len(mi.Pieces) == 0 || len(mi.Pieces)%sha1.Size != 0 {
+		return fmt.Errorf("invalid pieces")
+	}
+	if mi.Files != nil {
+		if mi.Length != 0 {
+			return fmt.Errorf("invalid info: both length and files present")
+		}
+		if mi.Length, err = totalLength(mi.Files); err != nil {
+			return err
+		}
+	}
+	if mi.Length <= 0 {
+		return fmt.Errorf("invalid length")
+	}
+	if n, want := len(mi.Pieces)/sha1.Size, piecesFor(mi.Length, mi.PieceLength); n != want {
+		return fmt.Errorf("invalid pieces: %d piece hashes for %d pieces", n, want)
+	}
+
+	return nil
+}
+
+// Bencode returns the bencoded info dictionary. If the MetaInfo was parsed,
+// the original encoding is returned unchanged.
 func (mi *MetaInfo) Bencode() (string, error) {
-	return bencode.BencodeVal(map[string]any{
-		"length":       mi.Length,
-		"name":         mi.Name,
-		"piece length": mi.PieceLength,
-		"pieces":       mi.Pieces,
-	})
+	if mi.raw != nil {
+		return string(mi.raw), nil
+	}
+
+	// Multi-file torrents have no top-level length key
+	info := *mi
+	if info.IsMultiFile() {
+		info.Length = 0
+	}
+
+	return bencode.BencodeVal(info)
 }
 
 // Sha1Sum calculates the SHA1 hash of the bencoded info dictionary.
@@ -85,31 +180,113 @@ func (mi *MetaInfo) pieceHashes() (ph []string) {
 	return
 }
 
+// MetaFile represents the contents of a .torrent file.
 type MetaFile struct {
-	Announce string
-	Info     MetaInfo
+	Announce string `bencode:"announce,omitempty"`
+	// AnnounceList holds tiers of tracker URLs (BEP 12),
+	// to be used instead of Announce if present
+	AnnounceList [][]string `bencode:"announce-list,omitempty"`
+	Comment      string     `bencode:"comment,omitempty"`
+	CreatedBy    string     `bencode:"created by,omitempty"`
+	// CreationDate is in seconds since the Unix epoch
+	CreationDate int64  `bencode:"creation date,omitempty"`
+	Encoding     string `bencode:"encoding,omitempty"`
+	// URLList holds the web seeds of the torrent (BEP 19)
+	URLList URLList `bencode:"url-list,omitempty"`
+	// PieceLayers maps the pieces roots of the files of v2 torrents
+	// to the concatenated hashes of their pieces
+	PieceLayers map[string]string `bencode:"piece layers,omitempty"`
+	Info        MetaInfo          `bencode:"-"`
 }
 
+// metaFileDict is the bencoded layout of a .torrent file. The info
+// dictionary is kept as raw bytes, so that the info hash is calculated
+// over the original encoding.
+type metaFileDict struct {
+	MetaFile
+	Info bencode.RawMessage `bencode:"info"`
+}
+
+// Trackers returns the tiers of tracker URLs of the torrent: the announce
+// list if it is present, otherwise a single tier with the announce URL,
+// or nil if the torrent has no trackers.
+func (mf *MetaFile) Trackers() [][]string {
+	if len(mf.AnnounceList) > 0 {
+		return mf.AnnounceList
+	}
+	if mf.Announce == "" {
+		return nil
+	}
+
+	return [][]string{{mf.Announce}}
+}
+
+// CreationTime returns the creation date of the torrent,
+// or the zero time if it has none.
+func (mf *MetaFile) CreationTime() time.Time {
+	if mf.CreationDate == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(mf.CreationDate, 0)
+}
+
+// Bencode returns the bencoded .torrent file. The info dictionary keeps
+// its original encoding, so the info hash doesn't change.
+func (mf *MetaFile) Bencode() ([]byte, error) {
+	info, err := mf.Info.Bencode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode info: %v", err)
+	}
+
+	return bencode.Marshal(metaFileDict{*mf, bencode.RawMessage(info)})
+}
+
+// NewMetaFileFromMap creates a new MetaFile instance from a decoded
+// .torrent file, such as a map holding the info dictionary received
+// from peers along with the trackers of a magnet link. The info dictionary
+// may be a bencode.RawMessage, which keeps the bytes it is hashed from.
 func NewMetaFileFromMap(m map[string]any) (*MetaFile, error) {
-	mf := new(MetaFile)
+	if m["info"] == nil {
+		return nil, fmt.Errorf("invalid info")
+	}
 
-	if announce, ok := m["announce"].(string); ok {
-		mf.Announce = announce
-	} else {
-		return nil, fmt.Errorf("invalid announce URL")
+	raw, err := bencode.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid .torrent file: %v", err)
 	}
-	if info, ok := m["info"].(map[string]any); ok {
-		info, err := NewMetaInfoFromMap(info)
-		if err != nil {
-			return nil, err
+
+	return ReadMetaFile(bytes.NewReader(raw))
+}
+
+// normalizeTrackers drops empty tiers from the announce list and fills in
+// Announce from the announce list if it's missing. A torrent may have no
+// trackers at all, its peers coming from elsewhere, such as the peers
+// listed in a magnet link.
+func (mf *MetaFile) normalizeTrackers() {
+	tiers := mf.AnnounceList[:0]
+
+	for _, tier := range mf.AnnounceList {
+		urls := tier[:0]
+		for _, url := range tier {
+			if url != "" {
+				urls = append(urls, url)
+			}
 		}
 
-		mf.Info = *info
-	} else {
-		return nil, fmt.Errorf("invalid info")
+		if len(urls) > 0 {
+			tiers = append(tiers, urls)
+		}
 	}
 
-	return mf, nil
+	mf.AnnounceList = tiers
+	if len(mf.AnnounceList) == 0 {
+		mf.AnnounceList = nil
+	}
+
+	if mf.Announce == "" && mf.AnnounceList != nil {
+		mf.Announce = mf.AnnounceList[0][0]
+	}
 }
 
 // ParseMetaFile parses a .torrent file and returns a MetaFile instance,
@@ -122,17 +299,58 @@ func ParseMetaFile(filename string) (*MetaFile, error) {
 
 	defer file.Close()
 
-	decoded, err := bencode.DecodeReader(file)
+	return ReadMetaFile(file)
+}
+
+// ReadMetaFile reads the contents of a .torrent file from r.
+func ReadMetaFile(r io.Reader) (*MetaFile, error) {
+	var dict metaFileDict
+
+	if err := bencode.NewDecoder(r).Decode(&dict); err != nil {
+		return nil, fmt.Errorf("invalid .torrent file: %v", err)
+	}
+
+	mf := &dict.MetaFile
+
+	mf.normalizeTrackers()
+
+	if dict.Info == nil {
+		return nil, fmt.Errorf("invalid info")
+	}
+
+	info, err := NewMetaInfoFromBytes(dict.Info)
 	if err != nil {
 		return nil, err
 	}
 
-	decodedMap, ok := decoded.(map[string]any)
-	if !ok {
-		return nil, fmt.Errorf("invalid .torrent file")
+	mf.Info = *info
+
+	if err := mf.validatePieceLayers(); err != nil {
+		return nil, err
+	}
+
+	return mf, nil
+}
+
+// URLList is a list of URLs, which may be encoded as a single string
+// when there is only one.
+type URLList []string
+
+// UnmarshalBencode accepts either a list of strings or a single string.
+func (l *URLList) UnmarshalBencode(data []byte) error {
+	if len(data) > 0 && isDigit(data[0]) {
+		var url string
+		if err := bencode.Unmarshal(data, &url); err != nil {
+			return err
+		}
+
+		*l = URLList{url}
+		return nil
 	}
 
-	metafile, err := NewMetaFileFromMap(decodedMap)
+	return bencode.Unmarshal(data, (*[]string)(l))
+}
 
-	return metafile, err
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
 }
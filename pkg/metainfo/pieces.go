@@ -0,0 +1,186 @@
+package metainfo
+
+import "sort"
+
+// FileSegment is the part of a file covered by a piece.
+type FileSegment struct {
+	// FileIndex is the index of the file in FileList
+	FileIndex int
+	// Offset is the offset of the segment within the file
+	Offset int64
+	Length int64
+}
+
+// isV2Only reports whether the pieces follow the v2 layout only, in which
+// every file starts at a piece boundary without padding files in between.
+func (mi *MetaInfo) isV2Only() bool {
+	return mi.IsV2() && !mi.IsHybrid()
+}
+
+// PieceCount returns the number of pieces of the torrent.
+func (mi *MetaInfo) PieceCount() int {
+	if !mi.isV2Only() {
+		return piecesFor(mi.Length, mi.PieceLength)
+	}
+
+	return mi.pieceCountV2()
+}
+
+// pieceCountV2 returns the number of pieces of the file tree,
+// in which every file starts at a piece boundary.
+func (mi *MetaInfo) pieceCountV2() int {
+	count := 0
+	for _, f := range mi.TreeFiles {
+		count += f.PieceCount(mi.PieceLength)
+	}
+
+	return count
+}
+
+// PieceSize returns the length of the piece, which is less than the piece
+// length for the last piece of the torrent, and for the last piece of each
+// file of v2-only torrents. It returns 0 if the index is out of bounds.
+func (mi *MetaInfo) PieceSize(pieceIdx int) int {
+	if mi.isV2Only() {
+		size, _ := mi.PieceSizeV2(pieceIdx)
+		return size
+	}
+
+	if pieceIdx < 0 || pieceIdx >= mi.PieceCount() {
+		return 0
+	}
+
+	begin := int64(pieceIdx) * int64(mi.PieceLength)
+
+	return int(min(mi.Length-begin, int64(mi.PieceLength)))
+}
+
+// PieceRange returns the segments of the files covered by the piece, in
+// order. Padding files are included, while empty files are left out.
+// It returns nil if the index is out of bounds.
+func (mi *MetaInfo) PieceRange(pieceIdx int) []FileSegment {
+	if mi.isV2Only() {
+		fileIdx, filePiece, ok := mi.LocatePieceV2(pieceIdx)
+		if !ok {
+			return nil
+		}
+
+		size, _ := mi.PieceSizeV2(pieceIdx)
+
+		return []FileSegment{{
+			FileIndex: fileIdx,
+			Offset:    int64(filePiece) * int64(mi.PieceLength),
+			Length:    int64(size),
+		}}
+	}
+
+	size := mi.PieceSize(pieceIdx)
+	if size == 0 {
+		return nil
+	}
+
+	begin := int64(pieceIdx) * int64(mi.PieceLength)
+	end := begin + int64(size)
+
+	layout := mi.fileLayout()
+	offsets := layout.offsets
+
+	// The first file ending after the beginning of the piece
+	i := sort.Search(len(layout.files), func(i int) bool {
+		return offsets[i+1] > begin
+	})
+
+	var segments []FileSegment
+
+	for ; i < len(layout.files) && offsets[i] < end; i++ {
+		if layout.files[i].Length == 0 {
+			continue
+		}
+
+		segBegin := max(begin, offsets[i])
+		segEnd := min(end, offsets[i+1])
+
+		segments = append(segments, FileSegment{
+			FileIndex: i,
+			Offset:    segBegin - offsets[i],
+			Length:    segEnd - segBegin,
+		})
+	}
+
+	return segments
+}
+
+// FilePieces returns the range of pieces [begin, end) covering the file
+// with the given index in FileList. The range is empty for empty files.
+func (mi *MetaInfo) FilePieces(fileIdx int) (begin, end int) {
+	layout := mi.fileLayout()
+	if fileIdx < 0 || fileIdx >= len(layout.files) {
+		return 0, 0
+	}
+
+	if mi.isV2Only() {
+		return layout.firstPieces[fileIdx], layout.firstPieces[fileIdx+1]
+	}
+
+	offset := layout.offsets[fileIdx]
+	length := layout.files[fileIdx].Length
+
+	// Empty files cover no piece, even in the middle of one
+	begin = int(offset / int64(mi.PieceLength))
+	if length == 0 {
+		return begin, begin
+	}
+
+	return begin, begin + piecesFor(offset%int64(mi.PieceLength)+length, mi.PieceLength)
+}
+
+// fileLayout locates the files of a torrent in its pieces, so that
+// finding the files of a piece doesn't take going through them all.
+type fileLayout struct {
+	// files is the FileList of the torrent
+	files []FileInfo
+	// offsets holds the offset of each file in the torrent,
+	// followed by the total length
+	offsets []int64
+	// firstPieces holds the first piece of each file of the file tree
+	// of v2 torrents, followed by the v2 piece count
+	firstPieces []int
+}
+
+// fileLayout returns the layout computed when the MetaInfo was parsed,
+// or computes it for a MetaInfo that was built otherwise.
+func (mi *MetaInfo) fileLayout() *fileLayout {
+	if mi.layout != nil {
+		return mi.layout
+	}
+
+	return mi.newFileLayout()
+}
+
+func (mi *MetaInfo) newFileLayout() *fileLayout {
+	layout := &fileLayout{
+		files:       mi.FileList(),
+		offsets:     []int64{0},
+		firstPieces: []int{0},
+	}
+
+	var offset int64
+	for _, f := range layout.files {
+		offset += f.Length
+		layout.offsets = append(layout.offsets, offset)
+	}
+
+	piece := 0
+	for _, f := range mi.TreeFiles {
+		piece += f.PieceCount(mi.PieceLength)
+		layout.firstPieces = append(layout.firstPieces, piece)
+	}
+
+	return layout
+}
+
+// piecesFor returns the number of pieces of the given length
+// needed for length bytes.
+func piecesFor(length int64, pieceLength int) int {
+	return int((length + int64(pieceLength) - 1) / int64(pieceLength))
+}
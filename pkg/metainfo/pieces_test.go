@@ -0,0 +1,104 @@
+package metainfo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testMultiFileInfo returns a v1 torrent of 3 pieces of 4 bytes
+// with files of 5, 0, 3, 0 and 4 bytes.
+func testMultiFileInfo(t *testing.T) *MetaInfo {
+	t.Helper()
+
+	files := []any{}
+	for i, length := range []int{5, 0, 3, 0, 4} {
+		files = append(files, map[string]any{
+			"length": length,
+			"path":   []any{string(rune('a' + i))},
+		})
+	}
+
+	mi, err := NewMetaInfoFromMap(map[string]any{
+		"name":         "dir",
+		"piece length": 4,
+		"pieces":       strings.Repeat("x", 3*20),
+		"files":        files,
+	})
+	if err != nil {
+		t.Fatalf("NewMetaInfoFromMap: %v", err)
+	}
+
+	return mi
+}
+
+// testV2OnlyInfo returns a v2-only torrent with files of 6, 0 and 3
+// bytes and pieces of 4 bytes, each file starting a piece.
+func testV2OnlyInfo() *MetaInfo {
+	return &MetaInfo{
+		Name:        "dir",
+		PieceLength: 4,
+		MetaVersion: MetaVersion2,
+		TreeFiles: []TreeFile{
+			{Path: []string{"x"}, Length: 6},
+			{Path: []string{"y"}, Length: 0},
+			{Path: []string{"z"}, Length: 3},
+		},
+	}
+}
+
+func TestPieceRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		mi       *MetaInfo
+		pieceIdx int
+		want     []FileSegment
+	}{
+		{"v1 first", testMultiFileInfo(t), 0, []FileSegment{{0, 0, 4}}},
+		{"v1 spanning", testMultiFileInfo(t), 1, []FileSegment{{0, 4, 1}, {2, 0, 3}}},
+		{"v1 last", testMultiFileInfo(t), 2, []FileSegment{{4, 0, 4}}},
+		{"v1 out of bounds", testMultiFileInfo(t), 3, nil},
+		{"v1 negative", testMultiFileInfo(t), -1, nil},
+		{"v2 first", testV2OnlyInfo(), 0, []FileSegment{{0, 0, 4}}},
+		{"v2 end of file", testV2OnlyInfo(), 1, []FileSegment{{0, 4, 2}}},
+		{"v2 after empty file", testV2OnlyInfo(), 2, []FileSegment{{2, 0, 3}}},
+		{"v2 out of bounds", testV2OnlyInfo(), 3, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mi.PieceRange(tt.pieceIdx); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PieceRange(%d) = %v, want %v", tt.pieceIdx, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilePieces(t *testing.T) {
+	tests := []struct {
+		name       string
+		mi         *MetaInfo
+		fileIdx    int
+		begin, end int
+	}{
+		{"v1 first", testMultiFileInfo(t), 0, 0, 2},
+		{"v1 empty", testMultiFileInfo(t), 1, 1, 1},
+		{"v1 within a piece", testMultiFileInfo(t), 2, 1, 2},
+		{"v1 empty at a boundary", testMultiFileInfo(t), 3, 2, 2},
+		{"v1 last", testMultiFileInfo(t), 4, 2, 3},
+		{"v1 out of bounds", testMultiFileInfo(t), 5, 0, 0},
+		{"v2 first", testV2OnlyInfo(), 0, 0, 2},
+		{"v2 empty", testV2OnlyInfo(), 1, 2, 2},
+		{"v2 last", testV2OnlyInfo(), 2, 2, 3},
+		{"v2 out of bounds", testV2OnlyInfo(), 3, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			begin, end := tt.mi.FilePieces(tt.fileIdx)
+			if begin != tt.begin || end != tt.end {
+				t.Errorf("FilePieces(%d) = [%d, %d), want [%d, %d)", tt.fileIdx, begin, end, tt.begin, tt.end)
+			}
+		})
+	}
+}
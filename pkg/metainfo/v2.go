@@ -0,0 +1,387 @@
+package metainfo
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/merkle"
+)
+
+// MetaVersion2 is the meta version of v2 and hybrid torrents (BEP 52).
+const MetaVersion2 = 2
+
+// TreeFile is a file of the file tree of a v2 torrent.
+type TreeFile struct {
+	// Path is the list of path components relative to the torrent
+	// directory, the last one being the file name.
+	Path   []string
+	Length int64
+	// PiecesRoot is the root of the merkle tree of the file,
+	// empty for empty files
+	PiecesRoot string
+	// Attr and SymlinkPath are as in FileInfo (BEP 47)
+	Attr        string
+	SymlinkPath []string
+}
+
+// PathString returns the path of the file joined with slashes.
+func (tf TreeFile) PathString() string {
+	return strings.Join(tf.Path, "/")
+}
+
+// PieceCount returns the number of pieces of the file.
+func (tf TreeFile) PieceCount(pieceLength int) int {
+	return piecesFor(tf.Length, pieceLength)
+}
+
+// IsV2 reports whether the torrent has v2 metadata,
+// which hybrid torrents have along with the v1 metadata.
+func (mi *MetaInfo) IsV2() bool {
+	return mi.MetaVersion == MetaVersion2
+}
+
+// IsHybrid reports whether the torrent has both v1 and v2 metadata.
+func (mi *MetaInfo) IsHybrid() bool {
+	return mi.IsV2() && mi.Pieces != ""
+}
+
+// validateV2 checks the v2 parts of the info dictionary
+// and fills in the files of the file tree.
+func (mi *MetaInfo) validateV2() (err error) {
+	if mi.PieceLength < merkle.BlockSize || mi.PieceLength&(mi.PieceLength-1) != 0 {
+		return fmt.Errorf("invalid piece length: %d is not a power of two of at least 16 KiB", mi.PieceLength)
+	}
+
+	if len(mi.FileTree) == 0 {
+		return fmt.Errorf("invalid info: missing file tree")
+	}
+
+	if mi.TreeFiles, err = parseFileTree(mi.FileTree); err != nil {
+		return err
+	}
+
+	var length int64
+	for _, f := range mi.TreeFiles {
+		length += f.Length
+	}
+
+	if !mi.IsHybrid() {
+		mi.Length = length
+		return nil
+	}
+
+	if err = mi.validateV1(); err != nil {
+		return err
+	}
+
+	if err = mi.validateHybridFiles(); err != nil {
+		return err
+	}
+
+	// The v1 pieces are aligned to the files by padding files,
+	// so both versions must have the same pieces
+	if n, want := piecesFor(mi.Length, mi.PieceLength), mi.pieceCountV2(); n != want {
+		return fmt.Errorf("invalid info: %d v1 pieces for %d v2 pieces", n, want)
+	}
+
+	return nil
+}
+
+// validateHybridFiles checks that the v1 files of a hybrid torrent,
+// leaving out padding files, are the files of the file tree.
+func (mi *MetaInfo) validateHybridFiles() error {
+	v1Files := mi.Files
+	if !mi.IsMultiFile() {
+		v1Files = []FileInfo{{Length: mi.Length, Path: []string{mi.Name}}}
+	}
+
+	i := 0
+	for _, f := range v1Files {
+		if f.IsPadding() {
+			continue
+		}
+
+		if i >= len(mi.TreeFiles) {
+			return fmt.Errorf("invalid info: v1 file %q is not in the file tree", f.PathString())
+		}
+
+		tf := mi.TreeFiles[i]
+		if f.PathString() != tf.PathString() || f.Length != tf.Length {
+			return fmt.Errorf("invalid info: v1 file %q doesn't match file tree file %q", f.PathString(), tf.PathString())
+		}
+
+		i++
+	}
+
+	if i != len(mi.TreeFiles) {
+		return fmt.Errorf("invalid info: file tree file %q is not in the v1 files", mi.TreeFiles[i].PathString())
+	}
+
+	return nil
+}
+
+// parseFileTree returns the files of a bencoded file tree, in the order
+// of their paths. Each file is a dictionary with an empty key, holding the
+// length and pieces root of the file.
+func parseFileTree(raw bencode.RawMessage) ([]TreeFile, error) {
+	var tree map[string]any
+	if err := bencode.Unmarshal(raw, &tree); err != nil {
+		return nil, fmt.Errorf("invalid file tree: %v", err)
+	}
+
+	var files []TreeFile
+	if err := walkFileTree(tree, nil, &files); err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("invalid file tree: no files")
+	}
+
+	return files, nil
+}
+
+func walkFileTree(node map[string]any, path []string, files *[]TreeFile) error {
+	if attrs, ok := node[""]; ok {
+		if len(path) == 0 || len(node) != 1 {
+			return fmt.Errorf("invalid file tree: unexpected file entry in %q", strings.Join(path, "/"))
+		}
+
+		f, err := newTreeFile(path, attrs)
+		if err != nil {
+			return err
+		}
+
+		*files = append(*files, f)
+		return nil
+	}
+
+	names := make([]string, 0, len(node))
+	for name := range node {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		child, ok := node[name].(map[string]any)
+		if !ok {
+			return fmt.Errorf("invalid file tree: %q is not a dictionary", strings.Join(append(path, name), "/"))
+		}
+
+		childPath := append(append([]string(nil), path...), name)
+		if err := walkFileTree(child, childPath, files); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newTreeFile(path []string, attrs any) (TreeFile, error) {
+	f := TreeFile{Path: path}
+
+	m, ok := attrs.(map[string]any)
+	if !ok {
+		return f, fmt.Errorf("invalid file tree: %q has no file attributes", f.PathString())
+	}
+
+	f.Length, ok = m["length"].(int64)
+	if !ok || f.Length < 0 {
+		return f, fmt.Errorf("invalid length of file %q", f.PathString())
+	}
+
+	root, hasRoot := m["pieces root"].(string)
+	switch {
+	case f.Length == 0 && hasRoot:
+		return f, fmt.Errorf("invalid pieces root of file %q: empty files have none", f.PathString())
+	case f.Length > 0 && len(root) != merkle.HashSize:
+		return f, fmt.Errorf("invalid pieces root of file %q", f.PathString())
+	}
+
+	f.PiecesRoot = root
+	f.Attr, _ = m["attr"].(string)
+
+	if symlinkPath, ok := m["symlink path"].([]any); ok {
+		for _, component := range symlinkPath {
+			c, ok := component.(string)
+			if !ok {
+				return f, fmt.Errorf("invalid symlink path of file %q", f.PathString())
+			}
+			f.SymlinkPath = append(f.SymlinkPath, c)
+		}
+	}
+
+	return f, nil
+}
+
+// Sha256Sum calculates the SHA256 hash of the bencoded info dictionary,
+// which is the info hash of v2 torrents.
+func (mi *MetaInfo) Sha256Sum() (string, error) {
+	bencoded, err := mi.Bencode()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(bencoded))
+
+	return string(sum[:]), nil
+}
+
+// validatePieceLayers checks the piece layers against the pieces roots of
+// the files. Files of a single piece have no layer, as the pieces root is
+// the hash of the piece. Missing layers are allowed, as they can be
+// requested from peers.
+func (mf *MetaFile) validatePieceLayers() error {
+	if !mf.Info.IsV2() {
+		if len(mf.PieceLayers) > 0 {
+			return fmt.Errorf("invalid piece layers: torrent is not v2")
+		}
+		return nil
+	}
+
+	for _, f := range mf.Info.TreeFiles {
+		layer, ok := mf.PieceLayers[f.PiecesRoot]
+		if !ok || f.Length <= int64(mf.Info.PieceLength) {
+			continue
+		}
+
+		hashes, err := merkle.HashesFromBytes([]byte(layer))
+		if err != nil {
+			return fmt.Errorf("invalid piece layer of file %q: %v", f.PathString(), err)
+		}
+
+		if err := mf.Info.verifyPieceLayer(f, hashes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyPieceLayer checks that the piece layer of the file has a hash
+// for every piece and adds up to the pieces root of the file.
+func (mi *MetaInfo) verifyPieceLayer(f TreeFile, layer []merkle.Hash) error {
+	pieceCount := piecesFor(f.Length, mi.PieceLength)
+
+	if len(layer) != pieceCount {
+		return fmt.Errorf("invalid piece layer of file %q: %d hashes for %d pieces", f.PathString(), len(layer), pieceCount)
+	}
+
+	root := merkle.Root(layer, merkle.Height(mi.PieceLength/merkle.BlockSize))
+	if string(root[:]) != f.PiecesRoot {
+		return fmt.Errorf("invalid piece layer of file %q: pieces root mismatch", f.PathString())
+	}
+
+	return nil
+}
+
+// PieceLayer returns the piece hashes of the file of a v2 torrent.
+// For files of a single piece, the pieces root is that hash.
+func (mf *MetaFile) PieceLayer(f TreeFile) ([]merkle.Hash, bool) {
+	if f.Length == 0 {
+		return nil, false
+	}
+
+	if f.Length <= int64(mf.Info.PieceLength) {
+		root, err := merkle.HashFromBytes([]byte(f.PiecesRoot))
+		return []merkle.Hash{root}, err == nil
+	}
+
+	layer, ok := mf.PieceLayers[f.PiecesRoot]
+	if !ok {
+		return nil, false
+	}
+
+	hashes, err := merkle.HashesFromBytes([]byte(layer))
+
+	return hashes, err == nil
+}
+
+// SetPieceLayer sets the piece layer of the file, such as one received
+// from peers, after checking it against the pieces root of the file.
+// Hashes past the last piece of the file, which pad the layer to a power
+// of two, are dropped.
+func (mf *MetaFile) SetPieceLayer(f TreeFile, layer []merkle.Hash) error {
+	if pieceCount := f.PieceCount(mf.Info.PieceLength); len(layer) > pieceCount {
+		layer = layer[:pieceCount]
+	}
+
+	if err := mf.Info.verifyPieceLayer(f, layer); err != nil {
+		return err
+	}
+
+	data := make([]byte, 0, len(layer)*merkle.HashSize)
+	for _, h := range layer {
+		data = append(data, h[:]...)
+	}
+
+	if mf.PieceLayers == nil {
+		mf.PieceLayers = make(map[string]string)
+	}
+	mf.PieceLayers[f.PiecesRoot] = string(data)
+
+	return nil
+}
+
+// LocatePieceV2 returns the index of the file of the file tree that the
+// piece belongs to, and the index of the piece within that file. Files of
+// v2 torrents start at piece boundaries, so a piece never spans files.
+func (mi *MetaInfo) LocatePieceV2(pieceIdx int) (fileIdx, filePiece int, ok bool) {
+	if pieceIdx < 0 {
+		return 0, 0, false
+	}
+
+	firstPieces := mi.fileLayout().firstPieces
+
+	// The first file ending after the piece, which is never an empty file
+	i := sort.Search(len(mi.TreeFiles), func(i int) bool {
+		return firstPieces[i+1] > pieceIdx
+	})
+	if i == len(mi.TreeFiles) {
+		return 0, 0, false
+	}
+
+	return i, pieceIdx - firstPieces[i], true
+}
+
+// PieceHashV2 returns the merkle root that the data of the piece hashes to,
+// along with the number of leaves of the subtree to hash the data into.
+func (mf *MetaFile) PieceHashV2(pieceIdx int) (hash merkle.Hash, numLeaves int, err error) {
+	fileIdx, filePiece, ok := mf.Info.LocatePieceV2(pieceIdx)
+	if !ok {
+		return hash, 0, fmt.Errorf("piece index out of bounds")
+	}
+
+	f := mf.Info.TreeFiles[fileIdx]
+
+	// The pieces root of a file of a single piece is the root of
+	// a tree just large enough for its blocks
+	if f.Length <= int64(mf.Info.PieceLength) {
+		blockCount := int((f.Length + merkle.BlockSize - 1) / merkle.BlockSize)
+		hash, err = merkle.HashFromBytes([]byte(f.PiecesRoot))
+		return hash, merkle.NextPowerOfTwo(blockCount), err
+	}
+
+	layer, ok := mf.PieceLayer(f)
+	if !ok {
+		return hash, 0, fmt.Errorf("missing piece layer of file %q", f.PathString())
+	}
+
+	return layer[filePiece], mf.Info.PieceLength / merkle.BlockSize, nil
+}
+
+// PieceSizeV2 returns the length of the file data in the piece, which is
+// less than the piece length for the last piece of a file.
+func (mi *MetaInfo) PieceSizeV2(pieceIdx int) (int, bool) {
+	fileIdx, filePiece, ok := mi.LocatePieceV2(pieceIdx)
+	if !ok {
+		return 0, false
+	}
+
+	remaining := mi.TreeFiles[fileIdx].Length - int64(filePiece)*int64(mi.PieceLength)
+
+	return int(min(remaining, int64(mi.PieceLength))), true
+}
@@ -0,0 +1,306 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/merkle"
+)
+
+// The v2 fixture is a torrent named "dir" with pieces of two blocks and
+// the files a, of three pieces the last of which ends with a short
+// block, b, of a single short block, and c, empty.
+const testV2PieceLength = 2 * merkle.BlockSize
+
+type v2Fixture struct {
+	a, b         []byte
+	layerA       []merkle.Hash
+	rootA, rootB merkle.Hash
+}
+
+func newV2Fixture() *v2Fixture {
+	f := &v2Fixture{
+		a: testData(2*testV2PieceLength+20000, 1),
+		b: testData(100, 2),
+	}
+
+	for data := f.a; len(data) > 0; {
+		n := min(len(data), testV2PieceLength)
+		f.layerA = append(f.layerA, merkle.DataRoot(data[:n], testV2PieceLength/merkle.BlockSize))
+		data = data[n:]
+	}
+
+	f.rootA = merkle.Root(f.layerA, merkle.Height(testV2PieceLength/merkle.BlockSize))
+	f.rootB = merkle.DataRoot(f.b, 1)
+
+	return f
+}
+
+// testData returns n bytes of a pattern depending on seed.
+func testData(n int, seed byte) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i%251) ^ seed
+	}
+
+	return data
+}
+
+func (f *v2Fixture) fileTree() map[string]any {
+	return map[string]any{
+		"a": map[string]any{"": map[string]any{"length": len(f.a), "pieces root": string(f.rootA[:])}},
+		"b": map[string]any{"": map[string]any{"length": len(f.b), "pieces root": string(f.rootB[:])}},
+		"c": map[string]any{"": map[string]any{"length": 0}},
+	}
+}
+
+func (f *v2Fixture) info() map[string]any {
+	return map[string]any{
+		"name":         "dir",
+		"piece length": testV2PieceLength,
+		"meta version": MetaVersion2,
+		"file tree":    f.fileTree(),
+	}
+}
+
+// hybridInfo returns the info of the fixture with the v1 files, a padding
+// file aligning b to a piece boundary.
+func (f *v2Fixture) hybridInfo() map[string]any {
+	padding := testV2PieceLength - len(f.a)%testV2PieceLength
+
+	v1Data := append(append(append([]byte(nil), f.a...), make([]byte, padding)...), f.b...)
+
+	var pieces []byte
+	for data := v1Data; len(data) > 0; {
+		n := min(len(data), testV2PieceLength)
+		sum := sha1.Sum(data[:n])
+		pieces = append(pieces, sum[:]...)
+		data = data[n:]
+	}
+
+	info := f.info()
+	info["pieces"] = string(pieces)
+	info["files"] = []any{
+		map[string]any{"length": len(f.a), "path": []any{"a"}},
+		map[string]any{"length": padding, "path": []any{".pad", "12768"}, "attr": "p"},
+		map[string]any{"length": len(f.b), "path": []any{"b"}},
+		map[string]any{"length": 0, "path": []any{"c"}},
+	}
+
+	return info
+}
+
+func (f *v2Fixture) layers() map[string]any {
+	var layer []byte
+	for _, h := range f.layerA {
+		layer = append(layer, h[:]...)
+	}
+
+	return map[string]any{string(f.rootA[:]): string(layer)}
+}
+
+func readTestMetaFile(t *testing.T, info map[string]any, layers map[string]any) (*MetaFile, error) {
+	t.Helper()
+
+	m := map[string]any{"info": info}
+	if layers != nil {
+		m["piece layers"] = layers
+	}
+
+	data, err := bencode.Marshal(m)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	return ReadMetaFile(bytes.NewReader(data))
+}
+
+func TestV2FileTree(t *testing.T) {
+	f := newV2Fixture()
+
+	mf, err := readTestMetaFile(t, f.info(), f.layers())
+	if err != nil {
+		t.Fatalf("ReadMetaFile: %v", err)
+	}
+
+	info := &mf.Info
+	if !info.IsV2() || info.IsHybrid() {
+		t.Errorf("IsV2, IsHybrid = %v, %v, want true, false", info.IsV2(), info.IsHybrid())
+	}
+	if info.Hash != info.HashV2[:sha1.Size] {
+		t.Errorf("Hash = %x, want the truncated v2 hash %x", info.Hash, info.HashV2[:sha1.Size])
+	}
+
+	wantFiles := []TreeFile{
+		{Path: []string{"a"}, Length: int64(len(f.a)), PiecesRoot: string(f.rootA[:])},
+		{Path: []string{"b"}, Length: int64(len(f.b)), PiecesRoot: string(f.rootB[:])},
+		{Path: []string{"c"}},
+	}
+	if !reflect.DeepEqual(info.TreeFiles, wantFiles) {
+		t.Errorf("TreeFiles = %+v, want %+v", info.TreeFiles, wantFiles)
+	}
+
+	if got, want := info.Length, int64(len(f.a)+len(f.b)); got != want {
+		t.Errorf("Length = %d, want %d", got, want)
+	}
+	if got := info.PieceCount(); got != 4 {
+		t.Errorf("PieceCount = %d, want 4", got)
+	}
+
+	pieces := []struct {
+		hash      merkle.Hash
+		numLeaves int
+		size      int
+	}{
+		{f.layerA[0], 2, testV2PieceLength},
+		{f.layerA[1], 2, testV2PieceLength},
+		{f.layerA[2], 2, 20000},
+		{f.rootB, 1, 100},
+	}
+	for i, want := range pieces {
+		hash, numLeaves, err := mf.PieceHashV2(i)
+		if err != nil {
+			t.Fatalf("PieceHashV2(%d): %v", i, err)
+		}
+		if hash != want.hash || numLeaves != want.numLeaves {
+			t.Errorf("PieceHashV2(%d) = %x, %d, want %x, %d", i, hash, numLeaves, want.hash, want.numLeaves)
+		}
+		if got := info.PieceSize(i); got != want.size {
+			t.Errorf("PieceSize(%d) = %d, want %d", i, got, want.size)
+		}
+	}
+}
+
+func TestV2PieceLayers(t *testing.T) {
+	f := newV2Fixture()
+
+	badHash := f.layers()
+	layer := []byte(badHash[string(f.rootA[:])].(string))
+	layer[0] ^= 1
+	badHash[string(f.rootA[:])] = string(layer)
+
+	tests := []struct {
+		name   string
+		layers map[string]any
+		err    string
+	}{
+		{"valid", f.layers(), ""},
+		{"missing", nil, ""},
+		{"wrong hash", badHash, "pieces root mismatch"},
+		{"missing hash", map[string]any{string(f.rootA[:]): string(layer[:2*merkle.HashSize])}, "2 hashes for 3 pieces"},
+		{"truncated hash", map[string]any{string(f.rootA[:]): string(layer[:merkle.HashSize+1])}, "invalid hashes length"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mf, err := readTestMetaFile(t, f.info(), tt.layers)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("ReadMetaFile error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadMetaFile: %v", err)
+			}
+
+			_, ok := mf.PieceLayer(mf.Info.TreeFiles[0])
+			if want := tt.layers != nil; ok != want {
+				t.Errorf("PieceLayer of a ok = %v, want %v", ok, want)
+			}
+
+			// Files of a single piece need no layer
+			if layer, ok := mf.PieceLayer(mf.Info.TreeFiles[1]); !ok || !reflect.DeepEqual(layer, []merkle.Hash{f.rootB}) {
+				t.Errorf("PieceLayer of b = %x, %v, want its pieces root", layer, ok)
+			}
+		})
+	}
+}
+
+func TestHybrid(t *testing.T) {
+	f := newV2Fixture()
+
+	mf, err := readTestMetaFile(t, f.hybridInfo(), f.layers())
+	if err != nil {
+		t.Fatalf("ReadMetaFile: %v", err)
+	}
+
+	info := &mf.Info
+	if !info.IsHybrid() {
+		t.Error("IsHybrid = false, want true")
+	}
+	if info.Hash == info.HashV2[:sha1.Size] {
+		t.Error("Hash is the truncated v2 hash, want the SHA1 hash")
+	}
+	if got := info.PieceCount(); got != 4 {
+		t.Errorf("PieceCount = %d, want 4", got)
+	}
+
+	// The v1 pieces include the padding file
+	ranges := map[int][]FileSegment{
+		2: {{0, 2 * testV2PieceLength, 20000}, {1, 0, 12768}},
+		3: {{2, 0, 100}},
+	}
+	for pieceIdx, want := range ranges {
+		if got := info.PieceRange(pieceIdx); !reflect.DeepEqual(got, want) {
+			t.Errorf("PieceRange(%d) = %v, want %v", pieceIdx, got, want)
+		}
+	}
+}
+
+func TestV2InvalidInfo(t *testing.T) {
+	f := newV2Fixture()
+
+	tests := []struct {
+		name   string
+		modify func(info map[string]any)
+		err    string
+	}{
+		{"piece length not a power of two", func(info map[string]any) {
+			info["piece length"] = 3 * merkle.BlockSize
+		}, "invalid piece length"},
+		{"piece length under a block", func(info map[string]any) {
+			info["piece length"] = merkle.BlockSize / 2
+		}, "invalid piece length"},
+		{"missing file tree", func(info map[string]any) {
+			delete(info, "file tree")
+		}, "missing file tree"},
+		{"file at the root", func(info map[string]any) {
+			info["file tree"] = map[string]any{"": map[string]any{"length": 0}}
+		}, "unexpected file entry"},
+		{"missing pieces root", func(info map[string]any) {
+			info["file tree"].(map[string]any)["a"] = map[string]any{"": map[string]any{"length": 1}}
+		}, "invalid pieces root"},
+		{"empty file with pieces root", func(info map[string]any) {
+			info["file tree"].(map[string]any)["c"] = map[string]any{"": map[string]any{"length": 0, "pieces root": string(f.rootB[:])}}
+		}, "empty files have none"},
+		{"hybrid file mismatch", func(info map[string]any) {
+			files := info["files"].([]any)
+			files[2] = map[string]any{"length": 99, "path": []any{"b"}}
+		}, "doesn't match file tree file"},
+		{"hybrid missing padding", func(info map[string]any) {
+			files := info["files"].([]any)
+			info["files"] = []any{files[0], files[2], files[3]}
+			info["pieces"] = info["pieces"].(string)[:3*sha1.Size]
+		}, "3 v1 pieces for 4 v2 pieces"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := f.info()
+			if strings.HasPrefix(tt.name, "hybrid") {
+				info = f.hybridInfo()
+			}
+			tt.modify(info)
+
+			_, err := readTestMetaFile(t, info, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ReadMetaFile error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package peer
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+)
+
+const (
+	// MetadataPieceSize is the size of the pieces the info dictionary
+	// is split into for the metadata exchange (BEP 9)
+	MetadataPieceSize = 16384 // 16KB
+	// MaxMetadataSize bounds the length of an info dictionary
+	// announced by a peer
+	MaxMetadataSize = 16 << 20 // 16MB
+	// utMetadataID is the ID we advertise for ut_metadata,
+	// which peers send their metadata messages with
+	utMetadataID = 1
+)
+
+// ErrMetadataRejected is returned when a peer rejects a request
+// for a piece of metadata.
+var ErrMetadataRejected = errors.New("metadata request rejected")
+
+// RequestMetadata requests all the pieces of the info dictionary from the
+// peer using the extension protocol, and checks it against the info hash.
+func (pc *PeerConn) RequestMetadata(infoHash string) ([]byte, error) {
+	if pc.metadataSize == 0 {
+		return nil, fmt.Errorf("peer didn't announce the metadata size")
+	}
+
+	pieces := make([][]byte, metadataPieceCount(pc.metadataSize))
+	if err := pc.requestMetadataPieces(pieces); err != nil {
+		return nil, err
+	}
+
+	metadata := bytes.Join(pieces, nil)
+	if err := VerifyMetadata(metadata, infoHash); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// FetchMetadata gets the info dictionary of the torrent from the peers,
+// moving on to the next peer when one fails or rejects a request. Pieces
+// received from earlier peers are kept, as long as peers agree on the
+// metadata size. The info dictionary is checked against the info hash.
+func FetchMetadata(peers []Peer, infoHash string) ([]byte, error) {
+	var (
+		pieces  [][]byte
+		size    int
+		lastErr = fmt.Errorf("no peers")
+	)
+
+	for _, p := range peers {
+		pc, err := NewPeerConnWithExtension(p, infoHash, true)
+		if err != nil {
+			lastErr = err
+			log.Printf("Skipping peer %v: %v\n", p, err)
+			continue
+		}
+
+		if pc.metadataSize == 0 {
+			pc.Close()
+			lastErr = fmt.Errorf("peer %v didn't announce the metadata size", p)
+			log.Printf("Skipping peer %v: %v\n", p, lastErr)
+			continue
+		}
+
+		if pc.metadataSize != size {
+			size = pc.metadataSize
+			pieces = make([][]byte, metadataPieceCount(size))
+		}
+
+		err = pc.requestMetadataPieces(pieces)
+		pc.Close()
+		if err != nil {
+			lastErr = err
+			log.Printf("Failed to get metadata from peer %v: %v\n", p, err)
+			continue
+		}
+
+		metadata := bytes.Join(pieces, nil)
+		if err := VerifyMetadata(metadata, infoHash); err != nil {
+			// There's no telling which peer sent the bad piece
+			lastErr = err
+			pieces, size = nil, 0
+			log.Printf("Discarding metadata from peer %v: %v\n", p, err)
+			continue
+		}
+
+		return metadata, nil
+	}
+
+	return nil, fmt.Errorf("failed to get metadata: %w", lastErr)
+}
+
+// VerifyMetadata checks the info dictionary against the info hash, which
+// is either the SHA1 hash of v1 torrents or the truncated SHA256 hash
+// of v2-only torrents.
+func VerifyMetadata(metadata []byte, infoHash string) error {
+	v1Hash := sha1.Sum(metadata)
+	if string(v1Hash[:]) == infoHash {
+		return nil
+	}
+
+	v2Hash := sha256.Sum256(metadata)
+	if len(infoHash) <= len(v2Hash) && string(v2Hash[:len(infoHash)]) == infoHash {
+		return nil
+	}
+
+	return fmt.Errorf("metadata doesn't match info hash %x", infoHash)
+}
+
+// requestMetadataPieces requests the pieces that are still missing.
+func (pc *PeerConn) requestMetadataPieces(pieces [][]byte) error {
+	for i := range pieces {
+		if pieces[i] != nil {
+			continue
+		}
+
+		piece, err := pc.RequestMetadataPiece(i)
+		if err != nil {
+			return fmt.Errorf("failed to get metadata piece %d: %w", i, err)
+		}
+
+		pieces[i] = piece
+	}
+
+	return nil
+}
+
+// RequestMetadataPiece requests a piece of the info dictionary from the peer.
+func (pc *PeerConn) RequestMetadataPiece(piece int) ([]byte, error) {
+	peerExtensionID, ok := pc.ExtensionID()
+	if !ok {
+		return nil, fmt.Errorf("peer doesn't support extension protocol")
+	}
+
+	if piece < 0 || piece >= metadataPieceCount(pc.metadataSize) {
+		return nil, fmt.Errorf("metadata piece index out of bounds")
+	}
+
+	extReq := NewExtensionPayload(ExtMsgID(peerExtensionID), map[string]any{
+		"msg_type": int(ExtMsgRequest),
+		"piece":    piece,
+	})
+	extReqData, err := extReq.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal extension request: %v", err)
+	}
+
+	if err := pc.sendPeerMsg(NewPeerMsg(MsgExtensionHandshake, extReqData)); err != nil {
+		return nil, fmt.Errorf("failed to send extension request: %v", err)
+	}
+
+	for {
+		msg, err := pc.waitForPeerMsg(MsgExtensionHandshake)
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive metadata message: %v", err)
+		}
+
+		res, err := NewExtensionPayloadFromBytes(msg.payload)
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip messages of other extensions
+		if res.id != utMetadataID {
+			continue
+		}
+
+		// Skip answers to other requests
+		if resPiece, _ := res.Payload["piece"].(int64); resPiece != int64(piece) {
+			continue
+		}
+
+		msgType, _ := res.Payload["msg_type"].(int64)
+		switch ExtMsgID(msgType) {
+		case ExtMsgData:
+			return pc.checkMetadataPiece(piece, res)
+		case ExtMsgReject:
+			return nil, ErrMetadataRejected
+		}
+	}
+}
+
+// checkMetadataPiece checks that the data message carries a whole piece
+// of the info dictionary whose size the peer announced.
+func (pc *PeerConn) checkMetadataPiece(piece int, res *ExtensionPayload) ([]byte, error) {
+	if totalSize, ok := res.Payload["total_size"].(int64); ok && totalSize != int64(pc.metadataSize) {
+		return nil, fmt.Errorf("invalid metadata total size: %d, expected %d", totalSize, pc.metadataSize)
+	}
+
+	expected := min(MetadataPieceSize, pc.metadataSize-piece*MetadataPieceSize)
+	if len(res.Data) != expected {
+		return nil, fmt.Errorf("invalid metadata piece length: %d, expected %d", len(res.Data), expected)
+	}
+
+	return res.Data, nil
+}
+
+// handleMetadataRequest answers the message if it is a request for a piece
+// of our metadata, with the piece or a reject if we don't have it, and
+// reports whether it was such a request.
+func (pc *PeerConn) handleMetadataRequest(msg *PeerMsg) bool {
+	if msg.id != MsgExtensionHandshake || len(msg.payload) == 0 || msg.payload[0] != utMetadataID {
+		return false
+	}
+
+	req, err := NewExtensionPayloadFromBytes(msg.payload)
+	if err != nil {
+		return false
+	}
+
+	msgType, _ := req.Payload["msg_type"].(int64)
+	piece, ok := req.Payload["piece"].(int64)
+	if ExtMsgID(msgType) != ExtMsgRequest || !ok {
+		return false
+	}
+
+	peerExtensionID, ok := pc.ExtensionID()
+	if !ok {
+		// The peer can't receive the answer
+		return true
+	}
+
+	res := NewExtensionPayload(ExtMsgID(peerExtensionID), map[string]any{
+		"msg_type": int(ExtMsgReject),
+		"piece":    piece,
+	})
+
+	if piece >= 0 && piece < int64(metadataPieceCount(len(pc.metadata))) {
+		begin := int(piece) * MetadataPieceSize
+		end := min(begin+MetadataPieceSize, len(pc.metadata))
+
+		res.Payload["msg_type"] = int(ExtMsgData)
+		res.Payload["total_size"] = len(pc.metadata)
+		res.Data = pc.metadata[begin:end]
+	}
+
+	resData, err := res.MarshalBinary()
+	if err != nil {
+		log.Printf("Failed to marshal metadata response: %v\n", err)
+		return true
+	}
+
+	if err := pc.sendPeerMsg(NewPeerMsg(MsgExtensionHandshake, resData)); err != nil {
+		log.Printf("Failed to send metadata response: %v\n", err)
+	}
+
+	return true
+}
+
+// metadataPieceCount returns the number of pieces of an info dictionary
+// of the given size.
+func metadataPieceCount(size int) int {
+	return (size + MetadataPieceSize - 1) / MetadataPieceSize
+}
@@ -10,8 +10,10 @@ import (
 	"log"
 	"math"
 	"net"
+	"slices"
 	"time"
 
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/merkle"
 	metainfo "github.com/codecrafters-io/bittorrent-starter-go/pkg/metainfo"
 )
 
@@ -19,32 +21,40 @@ const (
 	BlockSize      = 16384 // 16KB
 	PipelineDepth  = 5
 	MessageTimeout = 1 * time.Second
+	// ConnectTimeout bounds dialing a peer and the handshake with it
+	ConnectTimeout = 10 * time.Second
+	// MaxMsgLength bounds the length of a message accepted from a peer,
+	// so that a hostile length prefix can't exhaust memory
+	MaxMsgLength = 1 << 20 // 1MB
 )
 
 // PeerConn manages the connection to a peer
 type PeerConn struct {
 	conn        net.Conn
 	extensionID *uint8
-	id          string
-	Peer        Peer
+	// metadataSize is the length of the info dictionary announced
+	// by the peer in the extension handshake, 0 if unknown
+	metadataSize int
+	// metadata is our bencoded info dictionary, served to peers
+	// requesting it, nil if we don't have it yet
+	metadata []byte
+	id       string
+	// private disables peer sources other than trackers (BEP 27)
+	private bool
+	Peer    Peer
 }
 
+// extensionReserved are the reserved handshake bytes with the 20th bit
+// from the right set, indicating support for the extension protocol
+var extensionReserved = [8]byte{0, 0, 0, 0, 0, 0x10, 0, 0}
+
 // NewPeerConn creates a new connection to the peer and performs the handshake
 // with the peer.
 func NewPeerConn(peer Peer, infoHash string) (*PeerConn, error) {
-	conn, err := net.Dial("tcp", peer.String())
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to peer: %w", err)
-	}
+	pc := &PeerConn{Peer: peer}
 
-	pc := &PeerConn{
-		conn: conn,
-		Peer: peer,
-	}
-
-	pc.id, err = pc.handshake(infoHash, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to handshake with peer: %w", err)
+	if err := pc.connect(infoHash, nil); err != nil {
+		return nil, err
 	}
 
 	return pc, nil
@@ -53,66 +63,61 @@ func NewPeerConn(peer Peer, infoHash string) (*PeerConn, error) {
 // NewPeerConnWithExtension creates a new connection to the peer and performs
 // the extension handshake with the peer. The extension handshake is used to
 // indicate that the client supports the bittorrent extension protocol.
-func NewPeerConnWithExtension(peer Peer, infoHash string) (*PeerConn, error) {
-	conn, err := net.Dial("tcp", peer.String())
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to peer: %w", err)
-	}
-
+// Peer exchange is not advertised if private is set, which should be the
+// case for private torrents and for torrents whose metadata is unknown.
+func NewPeerConnWithExtension(peer Peer, infoHash string, private bool) (*PeerConn, error) {
 	pc := &PeerConn{
-		conn: conn,
-		Peer: peer,
+		private: private,
+		Peer:    peer,
 	}
 
-	// Set 20th bit from right to 1 to indicate that we support the extension protocol
-	pc.id, err = pc.handshake(infoHash, &[8]byte{0, 0, 0, 0, 0, 0x10, 0, 0})
-	if err != nil {
-		return nil, fmt.Errorf("failed to handshake with peer: %w", err)
+	if err := pc.connect(infoHash, &extensionReserved); err != nil {
+		return nil, err
 	}
 
 	return pc, nil
 }
 
-// RequestMetadata requests the metadata from the peer connection
-// using the bittorrent extension protocol and returns the metadata piece.
-func (pc *PeerConn) RequestMetadata() (metadataPiece *ExtensionPayload, err error) {
-	peerExtensionID, ok := pc.ExtensionID()
-	if !ok {
-		err = fmt.Errorf("peer doesn't support extension protocol")
-		return
+// NewPeerConnWithMetadata creates a new connection to the peer of a torrent
+// whose metadata we have, and performs the extension handshake with the peer.
+// The info dictionary is served to the peer if it asks for it (BEP 9).
+func NewPeerConnWithMetadata(peer Peer, mf *metainfo.MetaFile) (*PeerConn, error) {
+	metadata, err := mf.Info.Bencode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to bencode info: %v", err)
 	}
 
-	// Send metadata request
-	extReq := NewExtensionPayload(ExtMsgID(peerExtensionID), map[string]any{
-		"msg_type": int(ExtMsgRequest),
-		"piece":    0,
-	})
-	extReqData, err := extReq.MarshalBinary()
-	if err != nil {
-		err = fmt.Errorf("failed to marshal extension request: %v", err)
-		return
+	pc := &PeerConn{
+		private:  mf.Info.Private,
+		metadata: []byte(metadata),
+		Peer:     peer,
 	}
 
-	msg := NewPeerMsg(MsgExtensionHandshake, extReqData)
-	if err = pc.sendPeerMsg(msg); err != nil {
-		err = fmt.Errorf("failed to send extension request: %v", err)
-		return
+	if err := pc.connect(mf.Info.Hash, &extensionReserved); err != nil {
+		return nil, err
 	}
 
-	// Receive metadata piece
-	metadataMsg, err := pc.waitForPeerMsg(MsgExtensionHandshake)
+	return pc, nil
+}
+
+// connect dials the peer and performs the handshake within ConnectTimeout,
+// closing the connection if the handshake fails.
+func (pc *PeerConn) connect(infoHash string, reservedBytes *[8]byte) (err error) {
+	pc.conn, err = net.DialTimeout("tcp", pc.Peer.String(), ConnectTimeout)
 	if err != nil {
-		err = fmt.Errorf("failed to receive metadata message: %v", err)
-		return
+		return fmt.Errorf("failed to connect to peer: %w", err)
 	}
 
-	metadataPiece, err = NewExtensionPayloadFromBytes(metadataMsg.payload)
-	if err != nil {
-		err = fmt.Errorf("failed to create metadata piece: %v", err)
-		return
+	pc.conn.SetDeadline(time.Now().Add(ConnectTimeout))
+
+	if pc.id, err = pc.handshake(infoHash, reservedBytes); err != nil {
+		pc.conn.Close()
+		return fmt.Errorf("failed to handshake with peer: %w", err)
 	}
 
-	return
+	pc.conn.SetDeadline(time.Time{})
+
+	return nil
 }
 
 // PreDownload performs the setup for downloading a file from a peer connection
@@ -139,21 +144,11 @@ func (pc *PeerConn) PreDownload() error {
 func (pc *PeerConn) DownloadPiece(mf *metainfo.MetaFile, pieceIdx int) ([]byte, error) {
 	startTime := time.Now()
 
-	pieceLength := mf.Info.PieceLength
-	pieceCount := int(math.Ceil(float64(mf.Info.Length) / float64(pieceLength)))
-
-	if pieceIdx >= pieceCount {
+	if pieceIdx < 0 || pieceIdx >= mf.Info.PieceCount() {
 		return nil, fmt.Errorf("piece index out of bounds")
 	}
 
-	// Handle last piece
-	if pieceIdx == pieceCount-1 {
-		pieceLength = mf.Info.Length % mf.Info.PieceLength
-
-		if pieceLength == 0 {
-			pieceLength = mf.Info.PieceLength
-		}
-	}
+	pieceLength := mf.Info.PieceSize(pieceIdx)
 
 	pieceData := make([]byte, pieceLength)
 	blockCount := int(math.Ceil(float64(pieceLength) / float64(BlockSize)))
@@ -215,9 +210,96 @@ func (pc *PeerConn) DownloadPiece(mf *metainfo.MetaFile, pieceIdx int) ([]byte,
 
 	log.Printf("Piece %d downloaded from %s in %.3fs\n", pieceIdx, pc.Peer, time.Since(startTime).Seconds())
 
-	expectedHash := mf.Info.PieceHashes[pieceIdx]
+	return pieceData, verifyPiece(mf, pieceIdx, pieceData)
+}
+
+// maxHashesPerRequest bounds the number of hashes asked for in a single
+// hash request, as peers reject larger requests
+const maxHashesPerRequest = 512
+
+// FetchPieceLayers requests the piece layers missing from a v2 torrent,
+// such as one whose metadata came from peers, and adds them to mf.
+// Each chunk of hashes received is checked against the pieces root of the
+// file with the uncle hashes sent along.
+func (pc *PeerConn) FetchPieceLayers(mf *metainfo.MetaFile) error {
+	if !mf.Info.IsV2() {
+		return nil
+	}
+
+	for _, f := range mf.Info.TreeFiles {
+		if _, ok := mf.PieceLayer(f); ok || f.Length == 0 {
+			continue
+		}
+
+		layer, err := pc.requestPieceLayer(mf, f)
+		if err != nil {
+			return fmt.Errorf("failed to get piece layer of file %q: %v", f.PathString(), err)
+		}
+
+		if err := mf.SetPieceLayer(f, layer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// requestPieceLayer requests the piece layer of the file in chunks.
+func (pc *PeerConn) requestPieceLayer(mf *metainfo.MetaFile, f metainfo.TreeFile) ([]merkle.Hash, error) {
+	root, err := merkle.HashFromBytes([]byte(f.PiecesRoot))
+	if err != nil {
+		return nil, err
+	}
+
+	pieceCount := f.PieceCount(mf.Info.PieceLength)
+
+	// The layer is padded to a power of two,
+	// and so is the number of hashes per request
+	width := merkle.NextPowerOfTwo(pieceCount)
+	chunk := min(width, maxHashesPerRequest)
+	baseLayer := merkle.Height(mf.Info.PieceLength / merkle.BlockSize)
+	proofLayers := merkle.Height(width) - merkle.Height(chunk)
+
+	layer := make([]merkle.Hash, 0, width)
+
+	for index := 0; index < pieceCount; index += chunk {
+		req := HashRequestPayload{
+			piecesRoot:  root,
+			baseLayer:   uint32(baseLayer),
+			index:       uint32(index),
+			length:      uint32(chunk),
+			proofLayers: uint32(proofLayers),
+		}
+
+		if err := pc.sendPeerMsg(NewPeerMsg(MsgHashRequest, req.MarshalBinary())); err != nil {
+			return nil, fmt.Errorf("failed to send hash request: %w", err)
+		}
+
+		msg, err := pc.waitForPeerMsg(MsgHashes, MsgHashReject)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hashes: %w", err)
+		}
+		if msg.id == MsgHashReject {
+			return nil, fmt.Errorf("peer rejected hash request %v", req)
+		}
+
+		resp := HashesPayload{}
+		if err := resp.UnmarshalBinary(msg.payload); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal hashes payload: %w", err)
+		}
+		if resp.HashRequestPayload != req || len(resp.hashes) != chunk+proofLayers {
+			return nil, fmt.Errorf("unexpected hashes %v for request %v", resp, req)
+		}
 
-	return pieceData, verifyPiece(pieceData, expectedHash)
+		hashes, uncles := resp.hashes[:chunk], resp.hashes[chunk:]
+		if !merkle.VerifyProof(root, merkle.Root(hashes, baseLayer), index/chunk, uncles) {
+			return nil, fmt.Errorf("hashes %v don't match the pieces root", resp)
+		}
+
+		layer = append(layer, hashes...)
+	}
+
+	return layer, nil
 }
 
 // ID returns the peer connection ID
@@ -228,7 +310,17 @@ func (pc *PeerConn) ID() string {
 // ExtensionID returns the extension ID of the peer connection if it was
 // established, and a boolean indicating if the extension ID was set.
 func (pc *PeerConn) ExtensionID() (uint8, bool) {
-	return *pc.extensionID, pc.extensionID != nil
+	if pc.extensionID == nil {
+		return 0, false
+	}
+
+	return *pc.extensionID, true
+}
+
+// MetadataSize returns the length of the info dictionary announced by
+// the peer in the extension handshake, 0 if the peer didn't announce it.
+func (pc *PeerConn) MetadataSize() int {
+	return pc.metadataSize
 }
 
 // Close closes the peer connection
@@ -236,9 +328,45 @@ func (pc *PeerConn) Close() error {
 	return pc.conn.Close()
 }
 
-// verifyPiece checks if the SHA1 hash of the piece matches the expected hash
+// verifyPiece checks the piece against the SHA1 piece hash of v1 torrents
+// and the merkle tree of v2 torrents. Hybrid torrents are checked against
+// both, the v2 check leaving out the padding at the end of files.
+func verifyPiece(mf *metainfo.MetaFile, pieceIdx int, data []byte) error {
+	if len(mf.Info.PieceHashes) > 0 {
+		if err := verifyPieceSHA1(data, mf.Info.PieceHashes[pieceIdx]); err != nil {
+			return err
+		}
+	}
+
+	if !mf.Info.IsV2() {
+		return nil
+	}
+
+	size, ok := mf.Info.PieceSizeV2(pieceIdx)
+	if !ok || size > len(data) {
+		return fmt.Errorf("piece %d is not in the file tree", pieceIdx)
+	}
+
+	expected, numLeaves, err := mf.PieceHashV2(pieceIdx)
+	if err != nil {
+		return err
+	}
+
+	return verifyPieceMerkle(data[:size], expected, numLeaves)
+}
+
+// verifyPieceMerkle hashes the 16KB blocks of the piece and checks that they
+// add up to the expected merkle root.
+func verifyPieceMerkle(got []byte, expected merkle.Hash, numLeaves int) error {
+	if root := merkle.DataRoot(got, numLeaves); root != expected {
+		return fmt.Errorf("Merkle root mismatch: expected %x, got %x\n", expected, root)
+	}
+	return nil
+}
+
+// verifyPieceSHA1 checks if the SHA1 hash of the piece matches the expected hash
 // and returns an error if they do not match
-func verifyPiece(got []byte, expected string) error {
+func verifyPieceSHA1(got []byte, expected string) error {
 	hash := sha1.Sum(got)
 	hashStr := hex.EncodeToString(hash[:])
 
@@ -284,29 +412,36 @@ func (pc *PeerConn) handshake(infoHash string, reservedBytes *[8]byte) (peerID s
 	// Check if the peer supports the extension protocol
 	// (if the 20th bit of reserved bytes response and arg from the right is set to 1)
 	if reservedBytes != nil && reservedBytes[5]&0x10 != 0 && reserved[5]&0x10 != 0 {
-		extensionID, err := pc.extensionHandshake()
-		pc.extensionID = &extensionID
-
-		return peerID, err
+		return peerID, pc.extensionHandshake()
 	}
 
 	return
 }
 
 // extensionHandshake performs the extension handshake with the peer
-// and returns the extension ID of the peer if successful.
-func (pc *PeerConn) extensionHandshake() (peerExtID uint8, err error) {
+// and sets the ut_metadata extension ID of the peer. The peer must
+// support ut_metadata unless we have the metadata already.
+func (pc *PeerConn) extensionHandshake() (err error) {
 	if _, err = pc.waitForPeerMsg(MsgBitfield); err != nil {
 		err = fmt.Errorf("failed to receive bitfield message: %v", err)
 		return
 	}
 
-	extensionPayload := NewExtensionPayload(ExtMsgHandshake, map[string]any{
-		"m": map[string]any{
-			"ut_metadata": 1,
-			"ut_pex":      2,
-		},
-	})
+	extensions := map[string]any{
+		"ut_metadata": utMetadataID,
+	}
+	if !pc.private {
+		extensions["ut_pex"] = 2
+	}
+
+	handshake := map[string]any{
+		"m": extensions,
+	}
+	if pc.metadata != nil {
+		handshake["metadata_size"] = len(pc.metadata)
+	}
+
+	extensionPayload := NewExtensionPayload(ExtMsgHandshake, handshake)
 
 	payload, err := extensionPayload.MarshalBinary()
 	if err != nil {
@@ -332,13 +467,22 @@ func (pc *PeerConn) extensionHandshake() (peerExtID uint8, err error) {
 		return
 	}
 
-	utMetadata, ok := resPayload.Payload["m"].(map[string]any)["ut_metadata"].(int)
+	peerExtensions, _ := resPayload.Payload["m"].(map[string]any)
+
+	utMetadata, ok := peerExtensions["ut_metadata"].(int64)
 	if !ok {
-		err = fmt.Errorf("missing ut_metadata extension in handshake response")
+		if pc.metadata == nil {
+			err = fmt.Errorf("missing ut_metadata extension in handshake response")
+		}
 		return
 	}
 
-	peerExtID = uint8(utMetadata)
+	peerExtID := uint8(utMetadata)
+	pc.extensionID = &peerExtID
+
+	if size, ok := resPayload.Payload["metadata_size"].(int64); ok && size > 0 && size <= MaxMetadataSize {
+		pc.metadataSize = int(size)
+	}
 
 	return
 }
@@ -393,6 +537,9 @@ func (pc *PeerConn) readPeerMsg() (*PeerMsg, error) {
 	}
 
 	msgLen := binary.BigEndian.Uint32(lenBuf)
+	if msgLen > MaxMsgLength {
+		return nil, fmt.Errorf("message length %d exceeds limit of %d", msgLen, MaxMsgLength)
+	}
 
 	// Read payload if length > 0
 	var payload []byte
@@ -418,8 +565,8 @@ func (pc *PeerConn) readPeerMsg() (*PeerMsg, error) {
 	}, nil
 }
 
-// waitForPeerMsg waits for a specific message type
-func (pc *PeerConn) waitForPeerMsg(expectedID MsgID) (*PeerMsg, error) {
+// waitForPeerMsg waits for a message of one of the given types
+func (pc *PeerConn) waitForPeerMsg(expectedIDs ...MsgID) (*PeerMsg, error) {
 	msgChan := make(chan *PeerMsg, 1)
 	errChan := make(chan error, 1)
 	ctx, cancel := context.WithTimeout(context.Background(), MessageTimeout)
@@ -441,7 +588,12 @@ func (pc *PeerConn) waitForPeerMsg(expectedID MsgID) (*PeerMsg, error) {
 					return
 				}
 
-				if msg.id == expectedID {
+				// Answer metadata requests whatever we are waiting for
+				if pc.handleMetadataRequest(msg) {
+					continue
+				}
+
+				if slices.Contains(expectedIDs, msg.id) {
 					msgChan <- msg
 					return
 				}
@@ -452,7 +604,7 @@ func (pc *PeerConn) waitForPeerMsg(expectedID MsgID) (*PeerMsg, error) {
 				}
 
 				// Log other message types
-				log.Printf("GOT: %v while waiting for type %d\n", msg, expectedID)
+				log.Printf("GOT: %v while waiting for types %v\n", msg, expectedIDs)
 			}
 		}
 	}()
@@ -463,7 +615,7 @@ func (pc *PeerConn) waitForPeerMsg(expectedID MsgID) (*PeerMsg, error) {
 		return msg, nil
 	case err := <-errChan:
 		if err == context.DeadlineExceeded {
-			return nil, fmt.Errorf("timeout waiting for message ID %d", expectedID)
+			return nil, fmt.Errorf("timeout waiting for message IDs %v", expectedIDs)
 		}
 		return nil, err
 	}
@@ -1,13 +1,12 @@
 package peer
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
-	"strings"
 
 	"github.com/codecrafters-io/bittorrent-starter-go/pkg/bencode"
-	"github.com/codecrafters-io/bittorrent-starter-go/pkg/util"
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/merkle"
 )
 
 type MsgID uint8
@@ -23,6 +22,10 @@ const (
 	MsgPiece              MsgID = 7
 	MsgCancel             MsgID = 8
 	MsgExtensionHandshake MsgID = 20
+	// Messages of v2 torrents (BEP 52) to exchange merkle tree hashes
+	MsgHashRequest MsgID = 21
+	MsgHashes      MsgID = 22
+	MsgHashReject  MsgID = 23
 )
 
 type PeerMsg struct {
@@ -176,6 +179,86 @@ func (p *PiecePayload) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// HashRequestPayload is the payload of hash request and hash reject
+// messages, and the header of hashes messages. It asks for length hashes
+// of the merkle tree of the file with the given pieces root, starting at
+// index of the layer baseLayer levels above the leaves, along with the
+// uncle hashes of proofLayers ancestor layers.
+type HashRequestPayload struct {
+	piecesRoot  merkle.Hash
+	baseLayer   uint32
+	index       uint32
+	length      uint32
+	proofLayers uint32
+}
+
+// hashRequestLength is the length of a hash request payload
+const hashRequestLength = merkle.HashSize + 16
+
+func (p HashRequestPayload) String() string {
+	return fmt.Sprintf("HashRequestPayload{pieces_root: %x, base_layer: %v, index: %v, length: %v, proof_layers: %v}",
+		p.piecesRoot, p.baseLayer, p.index, p.length, p.proofLayers)
+}
+
+func (p HashRequestPayload) MarshalBinary() []byte {
+	payload := make([]byte, 0, hashRequestLength)
+
+	payload = append(payload, p.piecesRoot[:]...)
+	payload = binary.BigEndian.AppendUint32(payload, p.baseLayer)
+	payload = binary.BigEndian.AppendUint32(payload, p.index)
+	payload = binary.BigEndian.AppendUint32(payload, p.length)
+	payload = binary.BigEndian.AppendUint32(payload, p.proofLayers)
+
+	return payload
+}
+
+func (p *HashRequestPayload) UnmarshalBinary(data []byte) error {
+	if len(data) < hashRequestLength {
+		return fmt.Errorf("invalid hash request payload length")
+	}
+
+	copy(p.piecesRoot[:], data)
+	data = data[merkle.HashSize:]
+
+	p.baseLayer = binary.BigEndian.Uint32(data[:4])
+	p.index = binary.BigEndian.Uint32(data[4:8])
+	p.length = binary.BigEndian.Uint32(data[8:12])
+	p.proofLayers = binary.BigEndian.Uint32(data[12:16])
+
+	return nil
+}
+
+// HashesPayload is the payload of a hashes message: the requested hashes
+// followed by the uncle hashes, from the lowest layer up.
+type HashesPayload struct {
+	HashRequestPayload
+	hashes []merkle.Hash
+}
+
+func (p HashesPayload) String() string {
+	return fmt.Sprintf("HashesPayload{%v, hashes: %v}", p.HashRequestPayload, len(p.hashes))
+}
+
+func (p HashesPayload) MarshalBinary() []byte {
+	payload := p.HashRequestPayload.MarshalBinary()
+
+	for _, h := range p.hashes {
+		payload = append(payload, h[:]...)
+	}
+
+	return payload
+}
+
+func (p *HashesPayload) UnmarshalBinary(data []byte) (err error) {
+	if err = p.HashRequestPayload.UnmarshalBinary(data); err != nil {
+		return err
+	}
+
+	p.hashes, err = merkle.HashesFromBytes(data[hashRequestLength:])
+
+	return err
+}
+
 type ExtMsgID uint8
 
 // ExtMsgHandshake is a extension handshake message ID
@@ -190,8 +273,15 @@ const (
 	ExtMsgReject ExtMsgID = 2
 )
 
+// extensionMaxDepth bounds the nesting of bencoded extension messages,
+// which are shallow dictionaries
+const extensionMaxDepth = 64
+
 type ExtensionPayload struct {
 	Payload map[string]any
+	// Data is the metadata piece attached to data messages,
+	// following the bencoded dictionary
+	Data []byte
 	// The identifier can refer to a specific extension type
 	id ExtMsgID
 }
@@ -214,15 +304,16 @@ func (e *ExtensionPayload) String() string {
 }
 
 func (e *ExtensionPayload) MarshalBinary() ([]byte, error) {
-	payload := make([]byte, 0, len(e.Payload)+1)
-
-	bencodedPayload, err := bencode.BencodeVal(e.Payload)
+	bencodedPayload, err := bencode.Marshal(e.Payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to bencode extension payload: %v", err)
 	}
 
+	payload := make([]byte, 0, len(bencodedPayload)+len(e.Data)+1)
+
 	payload = append(payload, byte(e.id))
 	payload = append(payload, bencodedPayload...)
+	payload = append(payload, e.Data...)
 
 	return payload, nil
 }
@@ -236,31 +327,19 @@ func (e *ExtensionPayload) UnmarshalBinary(data []byte) error {
 
 	e.id = ExtMsgID(data[0])
 
-	payload := data[1:]
+	dec := bencode.NewDecoder(bytes.NewReader(data[1:]))
+	dec.SetLimits(bencode.Limits{MaxDepth: extensionMaxDepth})
 
-	payloadReader := bufio.NewReader(strings.NewReader(string(payload)))
-
-	decodedPayload, err := bencode.DecodeBufReader(payloadReader)
-	if err != nil {
+	if err := dec.Decode(&e.Payload); err != nil {
 		return fmt.Errorf("failed to decode extension payload: %v", err)
 	}
 
-	decodedPayloadMap, ok := decodedPayload.(map[string]any)
-	if !ok {
-		return fmt.Errorf("invalid extension payload")
-	}
-
-	e.Payload = decodedPayloadMap
-
-	// If there is any buffered data, it means there is a metadata piece
-	// attached to the extension message
-	if payloadReader.Buffered() > 0 {
-		metaPiece, err := bencode.DecodeBufReader(payloadReader)
-		if err != nil {
-			return fmt.Errorf("failed to decode extension payload: %v", err)
-		}
-
-		e.Payload["meta_piece"] = metaPiece
+	// If there is any data left after the dictionary, it means
+	// there is a metadata piece attached to the extension message.
+	// The piece is a slice of the bencoded info dictionary, not
+	// a bencoded value of its own.
+	if rest := data[1+dec.InputOffset():]; len(rest) > 0 {
+		e.Data = append([]byte(nil), rest...)
 	}
 
 	return nil
@@ -275,7 +354,7 @@ type HandshakeMsg struct {
 }
 
 func NewHandshakeMsg(infoHash string, reservedBytes *[8]byte) (*HandshakeMsg, error) {
-	peerId, err := util.GenRandStr(20)
+	peerId, err := LocalPeerID()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate peer ID: %v", err)
 	}
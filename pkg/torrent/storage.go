@@ -0,0 +1,272 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/metainfo"
+)
+
+// executablePerm is the mode of files with the executable attribute
+const executablePerm = 0o755
+
+// writePiecesToDir writes the pieces of a multi-file torrent into a
+// directory tree under outDir, rooted at a directory named after the
+// torrent. Piece data is split across file boundaries in file order.
+// Padding files are skipped, executable files get their executable bits
+// and symlinks are created once all files are written. Hidden files need
+// nothing more, as their names already hide them on Unix.
+func writePiecesToDir(outDir string, info *metainfo.MetaInfo, pieces []*Piece) error {
+	w := &multiFileWriter{}
+	defer w.Close()
+
+	var symlinks []metainfo.FileInfo
+
+	for _, f := range info.FileList() {
+		path, err := safeFilePath(outDir, f.Path)
+		if err != nil {
+			return err
+		}
+
+		if f.IsSymlink() {
+			symlinks = append(symlinks, f)
+		}
+
+		w.files = append(w.files, storageFile{path, f.Length, f})
+	}
+
+	for _, piece := range pieces {
+		if piece == nil {
+			continue
+		}
+
+		if _, err := w.Write(piece.data); err != nil {
+			return fmt.Errorf("failed to write piece %d: %v", piece.idx, err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	for _, f := range symlinks {
+		if err := createSymlink(outDir, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// storageFile is a file on disk with its expected length.
+type storageFile struct {
+	path   string
+	length int64
+	info   metainfo.FileInfo
+}
+
+// multiFileWriter writes a continuous stream of data across files,
+// moving on to the next file once the current one has its full length.
+type multiFileWriter struct {
+	files   []storageFile
+	current io.WriteCloser
+	idx     int   // index of the file being written
+	written int64 // bytes written to the file being written
+}
+
+func (w *multiFileWriter) Write(data []byte) (n int, err error) {
+	for len(data) > 0 {
+		if err = w.openNext(); err != nil {
+			return
+		}
+
+		remaining := w.files[w.idx].length - w.written
+		chunk := data
+		if int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+
+		var written int
+		written, err = w.current.Write(chunk)
+		n += written
+		w.written += int64(written)
+		if err != nil {
+			return
+		}
+
+		data = data[len(chunk):]
+	}
+
+	return
+}
+
+// openNext makes sure the current file has room left, closing full files
+// and creating the next ones. Empty files are created along the way.
+func (w *multiFileWriter) openNext() error {
+	for w.current == nil || w.written == w.files[w.idx].length {
+		if w.current != nil {
+			if err := w.current.Close(); err != nil {
+				return err
+			}
+			w.current = nil
+			w.idx++
+			w.written = 0
+		}
+
+		if w.idx >= len(w.files) {
+			return fmt.Errorf("data exceeds the total length of files")
+		}
+
+		f, err := openStorageFile(w.files[w.idx])
+		if err != nil {
+			return err
+		}
+		w.current = f
+	}
+
+	return nil
+}
+
+// Close closes the file being written and creates any remaining
+// empty files at the end of the list.
+func (w *multiFileWriter) Close() error {
+	if w.current != nil {
+		err := w.current.Close()
+		w.current = nil
+		w.idx++
+		w.written = 0
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for ; w.idx < len(w.files) && w.files[w.idx].length == 0; w.idx++ {
+		f, err := openStorageFile(w.files[w.idx])
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+
+	return nil
+}
+
+// discardWriter drops the data of padding files.
+type discardWriter struct{}
+
+func (discardWriter) Write(data []byte) (int, error) { return len(data), nil }
+func (discardWriter) Close() error                   { return nil }
+
+// openStorageFile creates the file on disk, unless it's padding
+// or a symlink to be created later.
+func openStorageFile(sf storageFile) (io.WriteCloser, error) {
+	if sf.info.IsPadding() || sf.info.IsSymlink() {
+		return discardWriter{}, nil
+	}
+
+	f, err := createFile(sf.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if sf.info.IsExecutable() {
+		if err := f.Chmod(executablePerm); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to make file executable: %v", err)
+		}
+	}
+
+	return f, nil
+}
+
+// createSymlink creates the symlink to the symlink path of the file,
+// relative to the directory of the link. Targets outside of outDir are
+// rejected in the same way as file paths.
+func createSymlink(outDir string, f metainfo.FileInfo) error {
+	path, err := safeFilePath(outDir, f.Path)
+	if err != nil {
+		return err
+	}
+
+	target, err := safeFilePath(outDir, f.SymlinkPath)
+	if err != nil {
+		return fmt.Errorf("invalid symlink target of %q: %v", f.PathString(), err)
+	}
+
+	target, err = filepath.Rel(filepath.Dir(path), target)
+	if err != nil {
+		return fmt.Errorf("invalid symlink target of %q: %v", f.PathString(), err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to replace file with symlink: %v", err)
+	}
+
+	if err := os.Symlink(target, path); err != nil {
+		return fmt.Errorf("failed to create symlink: %v", err)
+	}
+
+	return nil
+}
+
+func createFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %v", err)
+	}
+
+	return f, nil
+}
+
+// safeFilePath joins the torrent path components under dir. Components
+// that could escape dir, such as "..", are dropped and path separators
+// inside components are replaced, so a malicious torrent can't write
+// outside of dir.
+func safeFilePath(dir string, components []string) (string, error) {
+	clean := make([]string, 0, len(components)+1)
+	clean = append(clean, dir)
+
+	for _, c := range components {
+		c = strings.Map(func(r rune) rune {
+			if r == '/' || r == '\\' || r == 0 {
+				return '_'
+			}
+			return r
+		}, c)
+
+		if c == "" || c == "." || c == ".." {
+			continue
+		}
+
+		clean = append(clean, c)
+	}
+
+	if len(clean) == 1 {
+		return "", fmt.Errorf("invalid file path: %q", strings.Join(components, "/"))
+	}
+
+	path := filepath.Join(clean...)
+
+	// Guard against anything the sanitizing above missed,
+	// e.g. volume names on Windows
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("invalid file path: %q", strings.Join(components, "/"))
+	}
+
+	return path, nil
+}
@@ -1,70 +1,228 @@
 package torrent
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/codecrafters-io/bittorrent-starter-go/pkg/metainfo"
 	"github.com/codecrafters-io/bittorrent-starter-go/pkg/peer"
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/tracker"
 )
 
 type Torrent struct {
 	mf        *metainfo.MetaFile
 	workQueue chan *PieceWork
+	announcer *tracker.Announcer
+	// downloaded is the number of bytes of verified pieces
+	downloaded atomic.Int64
+
+	mu        sync.Mutex
 	peerConns []*peer.PeerConn
+	// startWorker downloads from a peer connected while a download
+	// is running, nil when there's none
+	startWorker func(pc *peer.PeerConn)
+	closed      bool
 }
 
+// NewTorrent announces the torrent to its trackers and connects to the
+// peers they return. Peers only come from the trackers of the torrent,
+// as is required for private torrents. The torrent keeps announcing
+// itself until it is closed, connecting to the new peers of each announce.
 func NewTorrent(mf *metainfo.MetaFile) (*Torrent, error) {
-	peersInfo, err := peer.DiscoverPeers(mf.Announce, mf.Info.Hash, mf.Info.Length)
+	return NewTorrentWithPeers(mf, nil)
+}
+
+// NewTorrentWithPeers is like NewTorrent, but also connects to the given
+// peers, such as the peers listed in a magnet link, which is enough for a
+// torrent without trackers. The given peers are ignored for private
+// torrents.
+func NewTorrentWithPeers(mf *metainfo.MetaFile, peers []peer.Peer) (*Torrent, error) {
+	t := &Torrent{
+		mf:        mf,
+		workQueue: make(chan *PieceWork, mf.Info.PieceCount()),
+	}
+
+	if mf.Info.Private {
+		peers = nil
+	}
+
+	t.announcer = tracker.NewAnnouncer(mf.Trackers(), mf.Info.Hash, t.stats)
+	t.announcer.OnPeers = t.addPeers
+
+	peersInfo, err := t.announcer.Start()
 	if err != nil {
-		return nil, fmt.Errorf("failed to discover peers: %v", err)
+		if len(peers) == 0 {
+			return nil, fmt.Errorf("failed to discover peers: %v", err)
+		}
+
+		if !errors.Is(err, tracker.ErrNoTrackers) {
+			log.Printf("Failed to announce: %v\n", err)
+		}
 	}
 
-	t := &Torrent{
-		mf,
-		make(chan *PieceWork, len(mf.Info.PieceHashes)),
-		make([]*peer.PeerConn, 0, len(peersInfo)),
+	// The given peers come first, then those of the trackers not among them
+	all := slices.Clone(peers)
+	for _, p := range peersInfo {
+		if !slices.ContainsFunc(peers, func(q peer.Peer) bool { return q.Addr == p.Addr }) {
+			all = append(all, p)
+		}
 	}
+	peersInfo = all
 
 	if err := t.connectPeers(peersInfo); err != nil {
+		t.Close()
 		return nil, fmt.Errorf("failed to connect to peers: %v", err)
 	}
 
+	if err := t.fetchPieceLayers(); err != nil {
+		t.Close()
+		return nil, err
+	}
+
 	return t, nil
 }
 
+// fetchPieceLayers requests the piece layers missing from a v2 torrent
+// from the peers, until one of them has them all.
+func (t *Torrent) fetchPieceLayers() (err error) {
+	t.mu.Lock()
+	peerConns := slices.Clone(t.peerConns)
+	t.mu.Unlock()
+
+	for _, pc := range peerConns {
+		if err = pc.FetchPieceLayers(t.mf); err == nil {
+			return nil
+		}
+
+		log.Printf("Failed to get piece layers from Peer %v: %v\n", pc.Peer, err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to get piece layers: %v", err)
+	}
+
+	return nil
+}
+
+// stats returns the transfer stats announced to the trackers.
+func (t *Torrent) stats() tracker.Stats {
+	downloaded := t.downloaded.Load()
+
+	return tracker.Stats{
+		Downloaded: downloaded,
+		Left:       max(t.mf.Info.Length-downloaded, 0),
+	}
+}
+
 func (t *Torrent) addPeerConn(pc *peer.PeerConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	t.peerConns = append(t.peerConns, pc)
 }
 
+// addPeers connects to the peers of a regular announce that the torrent
+// isn't connected to yet, and downloads from them if a download is running.
+// The peers are dialed in parallel, so that the announce loop is held up
+// for at most a few connect timeouts.
+func (t *Torrent) addPeers(peers []peer.Peer) {
+	peers = slices.DeleteFunc(slices.Clone(peers), t.hasPeer)
+
+	for _, pc := range t.dialPeers(peers) {
+		t.mu.Lock()
+		closed, startWorker := t.closed, t.startWorker
+		if !closed {
+			t.peerConns = append(t.peerConns, pc)
+		}
+		t.mu.Unlock()
+
+		if closed {
+			pc.Close()
+			continue
+		}
+
+		if startWorker != nil {
+			startWorker(pc)
+		}
+	}
+}
+
+// hasPeer reports whether the torrent has a connection to the peer.
+func (t *Torrent) hasPeer(p peer.Peer) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return slices.ContainsFunc(t.peerConns, func(pc *peer.PeerConn) bool {
+		return pc.Peer.Addr == p.Addr
+	})
+}
+
 func (t *Torrent) addPiece(p *PieceWork) {
 	t.workQueue <- p
 }
 
 const PieceDownloadRetries = 5
 
-// connectPeers connects to the peers in the given list. It creates a PeerConn
-// for each peer and performs a handshake with the peer. If the handshake is
-// successful, it adds the PeerConn to the Torrent's peerConns list.
-// If the handshake fails, it returns an error.
+// maxConcurrentDials bounds the peers being connected to at once.
+const maxConcurrentDials = 20
+
+// connectPeers connects to the peers in the given list and adds the
+// connections to the Torrent's peerConns list. Peers that can't be
+// connected to are skipped, it only fails if none could be.
 func (t *Torrent) connectPeers(peersInfo []peer.Peer) error {
-	for _, p := range peersInfo {
-		pc, err := peer.NewPeerConn(p, t.mf.Info.Hash)
-		if err != nil {
-			return fmt.Errorf("failed to create peer %v connection: %v", p, err)
-		}
+	pcs := t.dialPeers(peersInfo)
+	if len(pcs) == 0 {
+		return fmt.Errorf("none of the %d peers could be connected to", len(peersInfo))
+	}
 
+	for _, pc := range pcs {
 		t.addPeerConn(pc)
 	}
 
 	return nil
 }
 
+// dialPeers connects to the peers in parallel, performing the handshake
+// with each, and returns the connections of the peers it succeeded with.
+func (t *Torrent) dialPeers(peersInfo []peer.Peer) []*peer.PeerConn {
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, maxConcurrentDials)
+		pcs = make([]*peer.PeerConn, len(peersInfo))
+	)
+
+	for i, p := range peersInfo {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			pc, err := peer.NewPeerConnWithMetadata(p, t.mf)
+			if err != nil {
+				log.Printf("Failed to connect to Peer %v: %v\n", p, err)
+				return
+			}
+
+			pcs[i] = pc
+		}()
+	}
+
+	wg.Wait()
+
+	return slices.DeleteFunc(pcs, func(pc *peer.PeerConn) bool { return pc == nil })
+}
+
 // DownloadFile downloads the file from the torrent to the given output file.
-// It downloads the pieces concurrently from the available peers. If a piece
+// For multi-file torrents the output is a directory, in which the files are
+// created under a directory named after the torrent. It downloads the pieces concurrently from the available peers. If a piece
 // download fails, it retries a few times before giving up. If all pieces are
 // downloaded successfully, it writes the pieces to the output file.
 func (t *Torrent) DownloadFile(outFilename string) (err error) {
@@ -73,24 +231,31 @@ func (t *Torrent) DownloadFile(outFilename string) (err error) {
 	var wg sync.WaitGroup
 	waitCh := make(chan struct{})
 
-	pieceHashes := t.mf.Info.PieceHashes
+	pieceCount := t.mf.Info.PieceCount()
 
-	wg.Add(len(pieceHashes))
+	wg.Add(pieceCount)
 
-	for i, pieceHash := range pieceHashes {
-		pieceWork := NewPieceWork(&Piece{hash: pieceHash, idx: i})
+	for i := 0; i < pieceCount; i++ {
+		pieceWork := NewPieceWork(&Piece{idx: i})
 		t.addPiece(pieceWork)
 	}
 
-	errCh := make(chan error, len(pieceHashes))
+	errCh := make(chan error, pieceCount)
 
-	pieces := make([]*Piece, len(pieceHashes))
+	pieces := make([]*Piece, pieceCount)
 
-	// Worker function downloads pieces from peers
-	worker := func(pc *peer.PeerConn) {
+	// Worker function downloads pieces from peers. Peers connected
+	// during the download are dropped if they don't unchoke us,
+	// instead of failing the download.
+	worker := func(pc *peer.PeerConn, late bool) {
 		fmt.Printf("Goroutine for Peer %v started\n", pc.Peer)
 
 		if err := pc.PreDownload(); err != nil {
+			if late {
+				log.Printf("Failed to prepare download from Peer %v: %v\n", pc.Peer, err)
+				return
+			}
+
 			errCh <- fmt.Errorf("failed to prepare download: %v", err)
 			return
 		}
@@ -100,7 +265,7 @@ func (t *Torrent) DownloadFile(outFilename string) (err error) {
 
 			log.Printf("Downloading Piece %d from Peer %v\n", piece.idx, pc.Peer)
 
-			piece.data, err = pc.DownloadPiece(t.mf, piece.idx)
+			data, err := pc.DownloadPiece(t.mf, piece.idx)
 			if err != nil {
 				log.Printf("Attempting to download piece %d from Peer %v failed: %v\n", piece.idx, pc.Peer, err)
 
@@ -115,7 +280,9 @@ func (t *Torrent) DownloadFile(outFilename string) (err error) {
 				return
 			}
 
+			piece.data = data
 			pieces[piece.idx] = piece
+			t.downloaded.Add(int64(len(piece.data)))
 
 			wg.Done()
 		}
@@ -123,9 +290,23 @@ func (t *Torrent) DownloadFile(outFilename string) (err error) {
 		log.Printf("Goroutine for Peer %v finished\n", pc.Peer)
 	}
 
-	// Initialize worker for each peer
-	for _, pc := range t.peerConns {
-		go worker(pc)
+	// Initialize worker for each peer, and for
+	// the peers of announces during the download
+	t.mu.Lock()
+	peerConns := slices.Clone(t.peerConns)
+	t.startWorker = func(pc *peer.PeerConn) {
+		go worker(pc, true)
+	}
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		t.startWorker = nil
+		t.mu.Unlock()
+	}()
+
+	for _, pc := range peerConns {
+		go worker(pc, false)
 	}
 
 	go func() {
@@ -149,26 +330,45 @@ L:
 	close(t.workQueue)
 	close(errCh)
 
-	if err = writePiecesToOut(outFilename, pieces); err != nil {
+	// Multi-file torrents are written into a directory tree under outFilename
+	if t.mf.Info.IsMultiFile() {
+		err = writePiecesToDir(outFilename, &t.mf.Info, pieces)
+	} else if err = writePiecesToOut(outFilename, pieces); err == nil && t.mf.Info.FileList()[0].IsExecutable() {
+		err = os.Chmod(outFilename, executablePerm)
+	}
+	if err != nil {
 		err = fmt.Errorf("failed to write to output file: %v", err)
 		return
 	}
 
 	log.Printf("File %s successfully downloaded in %.3fs\n", outFilename, time.Since(startTime).Seconds())
 
+	if err := t.announcer.Completed(); err != nil {
+		log.Printf("Failed to announce completion: %v\n", err)
+	}
+
 	return
 }
 
-// Close closes all peer connections.
+// Close closes all peer connections and tells the
+// trackers that the torrent is stopped.
 func (t *Torrent) Close() {
-	for _, pc := range t.peerConns {
+	t.mu.Lock()
+	t.closed = true
+	peerConns := t.peerConns
+	t.mu.Unlock()
+
+	for _, pc := range peerConns {
 		pc.Close()
 	}
+
+	if err := t.announcer.Stop(); err != nil {
+		log.Printf("Failed to announce stop: %v\n", err)
+	}
 }
 
 // Piece represents a piece of the file to be downloaded.
 type Piece struct {
-	hash string
 	data []byte
 	idx  int
 }
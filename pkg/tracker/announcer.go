@@ -0,0 +1,242 @@
+package tracker
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/peer"
+)
+
+const (
+	// DefaultInterval is the time between regular announces
+	// when trackers don't give an interval
+	DefaultInterval = 30 * time.Minute
+	// minAnnounceInterval keeps trackers giving a tiny interval
+	// from being flooded with announces
+	minAnnounceInterval = 30 * time.Second
+)
+
+// Stats are the transfer stats of a torrent, in bytes.
+type Stats struct {
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+}
+
+// Announcer announces a torrent to its trackers over the lifetime of the
+// download: started when it begins, regular announces on the interval
+// given by the trackers, completed when the download completes and
+// stopped when the client is done with the torrent.
+type Announcer struct {
+	// Port is the port we accept peer connections on
+	Port uint16
+	// OnPeers is called with the peers of each regular announce
+	OnPeers func([]peer.Peer)
+
+	tiers    *Tiers
+	infoHash string
+	// stats reports the transfer stats sent with each announce
+	stats func() Stats
+
+	mu          sync.Mutex
+	trackerIDs  map[string]string
+	interval    time.Duration
+	minInterval time.Duration
+	started     bool
+	done        chan struct{}
+}
+
+// NewAnnouncer creates an announcer of the torrent with the given info hash
+// to its tracker tiers. stats is called for the transfer stats of each
+// announce.
+func NewAnnouncer(tiers [][]string, infoHash string, stats func() Stats) *Announcer {
+	return &Announcer{
+		Port:       DefaultPort,
+		tiers:      NewTiers(tiers),
+		infoHash:   infoHash,
+		stats:      stats,
+		trackerIDs: make(map[string]string),
+	}
+}
+
+// DiscoverPeers announces the torrent once to its trackers, without an
+// event, and returns the peers. left is the number of bytes left to
+// download.
+func DiscoverPeers(tiers [][]string, infoHash string, left int64) ([]peer.Peer, error) {
+	a := NewAnnouncer(tiers, infoHash, func() Stats {
+		return Stats{Left: left}
+	})
+
+	return a.Announce(EventNone)
+}
+
+// Announce announces the torrent with the event to the trackers, tier by
+// tier, and returns the peers of the trackers that responded, without
+// duplicates. The intervals and tracker ids given by the trackers are
+// kept for the next announces.
+func (a *Announcer) Announce(event Event) ([]peer.Peer, error) {
+	peerID, err := peer.LocalPeerID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate peer ID: %w", err)
+	}
+
+	stats := a.stats()
+
+	var (
+		peers []peer.Peer
+		seen  = make(map[string]bool)
+	)
+
+	err = a.tiers.each(func(announce string) error {
+		res, err := Announce(announce, &AnnounceRequest{
+			InfoHash:   a.infoHash,
+			PeerID:     peerID,
+			Port:       a.Port,
+			Uploaded:   stats.Uploaded,
+			Downloaded: stats.Downloaded,
+			Left:       stats.Left,
+			Event:      event,
+			TrackerID:  a.trackerID(announce),
+		})
+		if err != nil {
+			return err
+		}
+
+		if res.Warning != "" {
+			log.Printf("Tracker %v warning: %v\n", announce, res.Warning)
+		}
+
+		a.update(announce, res)
+
+		for _, p := range res.Peers {
+			if !seen[p.String()] {
+				seen[p.String()] = true
+				peers = append(peers, p)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return peers, nil
+}
+
+// Start sends the started event and returns the peers, then keeps
+// announcing the torrent on the interval given by the trackers
+// until Stop is called.
+func (a *Announcer) Start() ([]peer.Peer, error) {
+	peers, err := a.Announce(EventStarted)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.started = true
+	a.done = make(chan struct{})
+	done := a.done
+	a.mu.Unlock()
+
+	go a.run(done)
+
+	return peers, nil
+}
+
+// Completed sends the completed event, once the download completes,
+// if the started event was sent.
+func (a *Announcer) Completed() error {
+	a.mu.Lock()
+	started := a.started
+	a.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+
+	_, err := a.Announce(EventCompleted)
+	return err
+}
+
+// Stop stops the regular announces and sends the stopped event,
+// if the started event was sent.
+func (a *Announcer) Stop() error {
+	a.mu.Lock()
+	started := a.started
+	if started {
+		close(a.done)
+		a.started = false
+	}
+	a.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+
+	_, err := a.Announce(EventStopped)
+
+	return err
+}
+
+// Interval returns the time until the next regular announce.
+func (a *Announcer) Interval() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	interval := a.interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	return max(interval, a.minInterval, minAnnounceInterval)
+}
+
+func (a *Announcer) run(done chan struct{}) {
+	for {
+		timer := time.NewTimer(a.Interval())
+
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		peers, err := a.Announce(EventNone)
+		if err != nil {
+			log.Printf("Failed to announce: %v\n", err)
+			continue
+		}
+
+		if a.OnPeers != nil {
+			a.OnPeers(peers)
+		}
+	}
+}
+
+func (a *Announcer) trackerID(announce string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.trackerIDs[announce]
+}
+
+// update keeps the interval and tracker id given by the tracker.
+func (a *Announcer) update(announce string, res *AnnounceResponse) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if res.TrackerID != "" {
+		a.trackerIDs[announce] = res.TrackerID
+	}
+
+	if res.Interval > 0 {
+		a.interval = res.Interval
+	}
+	if res.MinInterval > 0 {
+		a.minInterval = res.MinInterval
+	}
+}
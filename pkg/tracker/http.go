@@ -0,0 +1,216 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/peer"
+)
+
+// maxTrackerResponseSize bounds the size of a tracker response body read.
+const maxTrackerResponseSize = 4 << 20 // 4MB
+
+// httpClient is the client used for HTTP trackers, which
+// must not hang the download when unresponsive.
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// httpResponse is the bencoded response of an HTTP tracker.
+type httpResponse struct {
+	FailureReason  *string `bencode:"failure reason"`
+	WarningMessage string  `bencode:"warning message"`
+	Interval       int64   `bencode:"interval"`
+	MinInterval    int64   `bencode:"min interval"`
+	TrackerID      string  `bencode:"tracker id"`
+	Complete       *int64  `bencode:"complete"`
+	Incomplete     *int64  `bencode:"incomplete"`
+	// Peers is either a compact string or a list of dictionaries
+	Peers  bencode.RawMessage `bencode:"peers"`
+	Peers6 *string            `bencode:"peers6"`
+}
+
+// peerDict is a peer of the non-compact form of the peer list.
+type peerDict struct {
+	PeerID string `bencode:"peer id,omitempty"`
+	IP     string `bencode:"ip"`
+	Port   int64  `bencode:"port"`
+}
+
+// announceHTTP sends the announce request to an HTTP tracker.
+func announceHTTP(u *url.URL, req *AnnounceRequest) (*AnnounceResponse, error) {
+	query := u.Query()
+	query.Set("info_hash", req.InfoHash)
+	query.Set("peer_id", req.PeerID)
+	query.Set("port", strconv.Itoa(int(req.Port)))
+	query.Set("uploaded", strconv.FormatInt(req.Uploaded, 10))
+	query.Set("downloaded", strconv.FormatInt(req.Downloaded, 10))
+	query.Set("left", strconv.FormatInt(req.Left, 10))
+	query.Set("compact", "1")
+	if req.Event != EventNone {
+		query.Set("event", req.Event.String())
+	}
+	if req.NumWant > 0 {
+		query.Set("numwant", strconv.Itoa(req.NumWant))
+	}
+	if req.TrackerID != "" {
+		query.Set("trackerid", req.TrackerID)
+	}
+
+	reqURL := *u
+	reqURL.RawQuery = query.Encode()
+
+	log.Printf("Requesting tracker: %v\n", reqURL.String())
+
+	body, err := httpGet(reqURL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return parseHTTPResponse(body)
+}
+
+// httpGet gets the body of the response of an HTTP tracker.
+func httpGet(url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Don't let a misbehaving tracker exhaust memory
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTrackerResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tracker response: %w", err)
+	}
+
+	// Trackers may explain an error status with a failure reason,
+	// which the caller gets from the body
+	if resp.StatusCode != http.StatusOK && len(body) == 0 {
+		return nil, fmt.Errorf("tracker responded with status %v", resp.Status)
+	}
+
+	return body, nil
+}
+
+// parseHTTPResponse parses the bencoded response of an HTTP tracker.
+func parseHTTPResponse(body []byte) (*AnnounceResponse, error) {
+	// Tracker responses are expected to be canonical,
+	// so reject anything malformed instead of guessing
+	var dict httpResponse
+	if err := bencode.UnmarshalStrict(body, &dict); err != nil {
+		return nil, fmt.Errorf("invalid tracker response: %w", err)
+	}
+
+	if dict.FailureReason != nil {
+		return nil, &FailureError{Reason: *dict.FailureReason}
+	}
+
+	if dict.Peers == nil && dict.Peers6 == nil {
+		return nil, fmt.Errorf("invalid tracker response: missing peers")
+	}
+
+	var peers []peer.Peer
+
+	if dict.Peers != nil {
+		var err error
+		if peers, err = parsePeerList(dict.Peers); err != nil {
+			return nil, err
+		}
+	}
+
+	if dict.Peers6 != nil {
+		peers6, err := parseCompactPeers(*dict.Peers6, net.IPv6len)
+		if err != nil {
+			return nil, err
+		}
+
+		peers = append(peers, peers6...)
+	}
+
+	res := &AnnounceResponse{
+		Interval:    time.Duration(dict.Interval) * time.Second,
+		MinInterval: time.Duration(dict.MinInterval) * time.Second,
+		TrackerID:   dict.TrackerID,
+		Warning:     dict.WarningMessage,
+		Seeders:     -1,
+		Leechers:    -1,
+		Peers:       peers,
+	}
+	if dict.Complete != nil {
+		res.Seeders = int(*dict.Complete)
+	}
+	if dict.Incomplete != nil {
+		res.Leechers = int(*dict.Incomplete)
+	}
+
+	return res, nil
+}
+
+// parsePeerList parses the peers of the tracker response, given either
+// in the compact form or as a list of dictionaries. Peers of the list
+// whose IP isn't an IP address, such as a host name, are skipped.
+func parsePeerList(raw bencode.RawMessage) ([]peer.Peer, error) {
+	if raw[0] != 'l' {
+		var compact string
+		if err := bencode.Unmarshal(raw, &compact); err != nil {
+			return nil, fmt.Errorf("invalid peers info: %w", err)
+		}
+
+		return parseCompactPeers(compact, net.IPv4len)
+	}
+
+	var list []peerDict
+	if err := bencode.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("invalid peers info: %w", err)
+	}
+
+	peers := make([]peer.Peer, 0, len(list))
+
+	for _, d := range list {
+		ip, err := netip.ParseAddr(d.IP)
+		if err != nil || d.Port <= 0 || d.Port > math.MaxUint16 {
+			log.Printf("Skipping peer %q port %d: invalid address\n", d.IP, d.Port)
+			continue
+		}
+
+		p := peer.NewPeer(netip.AddrPortFrom(ip, uint16(d.Port)))
+		p.ID = d.PeerID
+
+		peers = append(peers, p)
+	}
+
+	return peers, nil
+}
+
+// parseCompactPeers parses peers in the compact form, each peer being an
+// IP address of ipLen bytes followed by a big-endian 2 byte port. IPv4
+// peers are 6 bytes long, IPv6 peers 18 bytes long (BEP 7).
+func parseCompactPeers(peersInfo string, ipLen int) ([]peer.Peer, error) {
+	peerLen := ipLen + 2
+
+	if len(peersInfo)%peerLen != 0 {
+		return nil, fmt.Errorf("invalid peers info: length %d is not a multiple of %d", len(peersInfo), peerLen)
+	}
+
+	peers := make([]peer.Peer, 0, len(peersInfo)/peerLen)
+
+	for i := 0; i < len(peersInfo); i += peerLen {
+		entry := []byte(peersInfo[i : i+peerLen])
+
+		ip, _ := netip.AddrFromSlice(entry[:ipLen])
+		port := binary.BigEndian.Uint16(entry[ipLen:])
+
+		peers = append(peers, peer.NewPeer(netip.AddrPortFrom(ip, port)))
+	}
+
+	return peers, nil
+}
@@ -0,0 +1,172 @@
+package tracker
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/peer"
+)
+
+func testPeer(addr, id string) peer.Peer {
+	return peer.Peer{Addr: netip.MustParseAddrPort(addr), ID: id}
+}
+
+func TestParseHTTPResponse(t *testing.T) {
+	const (
+		v4Peers = "\x7f\x00\x00\x01\x1a\xe1\x0a\x00\x00\x02\x1a\xe2"
+		v6Peer  = "\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe3"
+	)
+
+	tests := []struct {
+		name  string
+		resp  map[string]any
+		peers []peer.Peer
+		err   string
+	}{
+		{
+			name:  "compact peers",
+			resp:  map[string]any{"peers": v4Peers},
+			peers: []peer.Peer{testPeer("127.0.0.1:6881", ""), testPeer("10.0.0.2:6882", "")},
+		},
+		{
+			name:  "no peers",
+			resp:  map[string]any{"peers": ""},
+			peers: []peer.Peer{},
+		},
+		{
+			name: "dictionary peers",
+			resp: map[string]any{"peers": []any{
+				map[string]any{"peer id": "-XX0001-000000000001", "ip": "127.0.0.1", "port": 6881},
+				map[string]any{"ip": "2001:db8::1", "port": 6883},
+				map[string]any{"ip": "::ffff:10.0.0.2", "port": 6882},
+			}},
+			peers: []peer.Peer{
+				testPeer("127.0.0.1:6881", "-XX0001-000000000001"),
+				testPeer("[2001:db8::1]:6883", ""),
+				testPeer("10.0.0.2:6882", ""),
+			},
+		},
+		{
+			name: "dictionary peers with bad addresses",
+			resp: map[string]any{"peers": []any{
+				map[string]any{"ip": "tracker.example", "port": 6881},
+				map[string]any{"ip": "300.0.0.1", "port": 6881},
+				map[string]any{"ip": "", "port": 6881},
+				map[string]any{"ip": "127.0.0.1", "port": 0},
+				map[string]any{"ip": "127.0.0.1", "port": 65536},
+				map[string]any{"ip": "127.0.0.1", "port": 6881},
+			}},
+			peers: []peer.Peer{testPeer("127.0.0.1:6881", "")},
+		},
+		{
+			name:  "peers6 only",
+			resp:  map[string]any{"peers6": v6Peer},
+			peers: []peer.Peer{testPeer("[2001:db8::1]:6883", "")},
+		},
+		{
+			name:  "peers and peers6",
+			resp:  map[string]any{"peers": v4Peers[:6], "peers6": v6Peer},
+			peers: []peer.Peer{testPeer("127.0.0.1:6881", ""), testPeer("[2001:db8::1]:6883", "")},
+		},
+		{
+			name: "compact peers of bad length",
+			resp: map[string]any{"peers": v4Peers[:7]},
+			err:  "length 7 is not a multiple of 6",
+		},
+		{
+			name: "peers6 of bad length",
+			resp: map[string]any{"peers": "", "peers6": v6Peer + "\x00"},
+			err:  "length 19 is not a multiple of 18",
+		},
+		{
+			name: "ip not a string",
+			resp: map[string]any{"peers": []any{map[string]any{"ip": 1, "port": 6881}}},
+			err:  "invalid peers info",
+		},
+		{
+			name: "peers not a string or list",
+			resp: map[string]any{"peers": 1},
+			err:  "invalid peers info",
+		},
+		{
+			name: "missing peers",
+			resp: map[string]any{"interval": 1800},
+			err:  "missing peers",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := bencode.Marshal(tt.resp)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+
+			res, err := parseHTTPResponse(body)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("parseHTTPResponse error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHTTPResponse: %v", err)
+			}
+
+			if !reflect.DeepEqual(res.Peers, tt.peers) {
+				t.Errorf("Peers = %v, want %v", res.Peers, tt.peers)
+			}
+		})
+	}
+}
+
+func TestParseHTTPResponseFields(t *testing.T) {
+	body, err := bencode.Marshal(map[string]any{
+		"interval":        1800,
+		"min interval":    60,
+		"tracker id":      "abc",
+		"warning message": "slow down",
+		"complete":        3,
+		"peers":           "",
+	})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	res, err := parseHTTPResponse(body)
+	if err != nil {
+		t.Fatalf("parseHTTPResponse: %v", err)
+	}
+
+	want := &AnnounceResponse{
+		Interval:    30 * time.Minute,
+		MinInterval: time.Minute,
+		TrackerID:   "abc",
+		Warning:     "slow down",
+		Seeders:     3,
+		Leechers:    -1,
+		Peers:       []peer.Peer{},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("parseHTTPResponse = %+v, want %+v", res, want)
+	}
+}
+
+func TestParseHTTPResponseFailure(t *testing.T) {
+	body, err := bencode.Marshal(map[string]any{"failure reason": "torrent not registered"})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	_, err = parseHTTPResponse(body)
+
+	var failure *FailureError
+	if !errors.As(err, &failure) || failure.Reason != "torrent not registered" {
+		t.Errorf("parseHTTPResponse error = %v, want a *FailureError", err)
+	}
+}
@@ -0,0 +1,120 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/bencode"
+)
+
+// ErrScrapeNotSupported is returned for HTTP trackers whose announce URL
+// doesn't follow the convention the scrape URL is derived by.
+var ErrScrapeNotSupported = errors.New("tracker doesn't support scrape")
+
+// scrapeResponse is the bencoded scrape response of an HTTP tracker.
+type scrapeResponse struct {
+	FailureReason *string                   `bencode:"failure reason"`
+	Files         map[string]scrapeFileDict `bencode:"files"`
+}
+
+type scrapeFileDict struct {
+	Complete   int64 `bencode:"complete"`
+	Downloaded int64 `bencode:"downloaded"`
+	Incomplete int64 `bencode:"incomplete"`
+}
+
+// ScrapeURL returns the scrape URL of an HTTP tracker, derived from the
+// announce URL by replacing "announce" with "scrape" at the start of its
+// last path component. UDP trackers scrape at their announce URL.
+func ScrapeURL(announce string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return "", fmt.Errorf("invalid announce URL: %w", err)
+	}
+
+	if u.Scheme == "udp" {
+		return announce, nil
+	}
+
+	dir, last := path.Split(u.Path)
+	if !strings.HasPrefix(last, "announce") {
+		return "", ErrScrapeNotSupported
+	}
+
+	u.Path = dir + "scrape" + strings.TrimPrefix(last, "announce")
+	u.RawPath = ""
+
+	return u.String(), nil
+}
+
+// Scrape asks the tracker at the announce URL for the stats of the swarms
+// of the torrents with the given info hashes, without joining them. Info
+// hashes the tracker doesn't know are left out of the results.
+func Scrape(announce string, infoHashes ...string) (map[string]ScrapeResult, error) {
+	for _, infoHash := range infoHashes {
+		if len(infoHash) != 20 {
+			return nil, fmt.Errorf("invalid info hash length: %d", len(infoHash))
+		}
+	}
+
+	scrape, err := ScrapeURL(announce)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(scrape)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scrape URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return scrapeHTTP(u, infoHashes)
+	case "udp":
+		return scrapeUDP(u, infoHashes)
+	default:
+		return nil, fmt.Errorf("unsupported tracker protocol: %q", u.Scheme)
+	}
+}
+
+// scrapeHTTP sends a scrape request for the info hashes to an HTTP tracker.
+func scrapeHTTP(u *url.URL, infoHashes []string) (map[string]ScrapeResult, error) {
+	query := u.Query()
+	for _, infoHash := range infoHashes {
+		query.Add("info_hash", infoHash)
+	}
+
+	reqURL := *u
+	reqURL.RawQuery = query.Encode()
+
+	log.Printf("Requesting tracker: %v\n", reqURL.String())
+
+	body, err := httpGet(reqURL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var dict scrapeResponse
+	if err := bencode.UnmarshalStrict(body, &dict); err != nil {
+		return nil, fmt.Errorf("invalid tracker response: %w", err)
+	}
+
+	if dict.FailureReason != nil {
+		return nil, &FailureError{Reason: *dict.FailureReason}
+	}
+
+	results := make(map[string]ScrapeResult, len(dict.Files))
+	for infoHash, f := range dict.Files {
+		results[infoHash] = ScrapeResult{
+			Seeders:   int(f.Complete),
+			Leechers:  int(f.Incomplete),
+			Completed: int(f.Downloaded),
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,405 @@
+package tracker
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/peer"
+)
+
+const (
+	// DefaultServerInterval is the announce interval given by the server
+	DefaultServerInterval = 30 * time.Minute
+	// defaultNumWant and maxNumWant bound the peers in an announce response
+	defaultNumWant = 50
+	maxNumWant     = 200
+)
+
+// Server is a BitTorrent tracker, keeping the swarms of the torrents
+// announced to it in memory. It serves announces and scrapes over HTTP,
+// as an http.Handler, and over UDP (BEP 15) with ServeUDP.
+type Server struct {
+	// Interval is the announce interval given to peers
+	Interval time.Duration
+	// PeerTTL is the time after which a peer that stopped
+	// announcing is dropped from its swarm
+	PeerTTL time.Duration
+	// Allowlist holds the info hashes of the torrents that may be
+	// announced, any torrent if nil
+	Allowlist map[string]bool
+
+	mu     sync.Mutex
+	swarms map[string]*swarm
+	// secret keys the connection IDs of UDP clients
+	secret [16]byte
+}
+
+// swarm holds the peers of a torrent, by peer ID.
+type swarm struct {
+	peers     map[string]*swarmPeer
+	completed int
+}
+
+type swarmPeer struct {
+	peer.Peer
+	left     int64
+	lastSeen time.Time
+}
+
+// announceParams are the parameters of an announce to the server.
+type announceParams struct {
+	infoHash string
+	peerID   string
+	addr     netip.AddrPort
+	left     int64
+	event    Event
+	numWant  int
+}
+
+// announceResult is what the server answers an announce with.
+type announceResult struct {
+	peers    []peer.Peer
+	seeders  int
+	leechers int
+}
+
+// errNotAllowed is the failure of announces of torrents missing from the
+// allowlist.
+var errNotAllowed = errors.New("torrent not allowed on this tracker")
+
+// NewServer creates a tracker server without any swarm.
+func NewServer() *Server {
+	s := &Server{
+		Interval: DefaultServerInterval,
+		PeerTTL:  2 * DefaultServerInterval,
+		swarms:   make(map[string]*swarm),
+	}
+
+	rand.Read(s.secret[:])
+
+	return s
+}
+
+// ListenAndServe serves HTTP announces and scrapes on addr, and UDP ones
+// on the same address if udp is set. Peers that stopped announcing are
+// dropped as they expire.
+func (s *Server) ListenAndServe(addr string, udp bool) error {
+	go s.expireLoop()
+
+	if udp {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on UDP: %w", err)
+		}
+		defer conn.Close()
+
+		go func() {
+			if err := s.ServeUDP(conn); err != nil {
+				log.Printf("UDP tracker stopped: %v\n", err)
+			}
+		}()
+	}
+
+	return http.ListenAndServe(addr, s)
+}
+
+// ServeHTTP serves announces at /announce and scrapes at /scrape.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		body any
+		err  error
+	)
+
+	switch r.URL.Path {
+	case "/announce":
+		body, err = s.serveHTTPAnnounce(r)
+	case "/scrape":
+		body, err = s.serveHTTPScrape(r)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		body = map[string]string{"failure reason": err.Error()}
+	}
+
+	data, err := bencode.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(data)
+}
+
+// httpAnnounceResponse is the bencoded response to an HTTP announce.
+type httpAnnounceResponse struct {
+	Interval   int64  `bencode:"interval"`
+	Complete   int64  `bencode:"complete"`
+	Incomplete int64  `bencode:"incomplete"`
+	Peers      any    `bencode:"peers"`
+	Peers6     string `bencode:"peers6,omitempty"`
+}
+
+func (s *Server) serveHTTPAnnounce(r *http.Request) (any, error) {
+	query := r.URL.Query()
+
+	params := announceParams{
+		infoHash: query.Get("info_hash"),
+		peerID:   query.Get("peer_id"),
+	}
+
+	if len(params.infoHash) != 20 {
+		return nil, fmt.Errorf("invalid info_hash")
+	}
+	if len(params.peerID) != 20 {
+		return nil, fmt.Errorf("invalid peer_id")
+	}
+
+	port, err := strconv.ParseUint(query.Get("port"), 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("invalid port")
+	}
+
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote address")
+	}
+	params.addr = netip.AddrPortFrom(remote.Addr().Unmap(), uint16(port))
+
+	if params.left, err = strconv.ParseInt(query.Get("left"), 10, 64); err != nil || params.left < 0 {
+		return nil, fmt.Errorf("invalid left")
+	}
+
+	if params.event, err = parseEvent(query.Get("event")); err != nil {
+		return nil, err
+	}
+
+	if numWant := query.Get("numwant"); numWant != "" {
+		if params.numWant, err = strconv.Atoi(numWant); err != nil {
+			return nil, fmt.Errorf("invalid numwant")
+		}
+	}
+
+	res, err := s.announce(params)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &httpAnnounceResponse{
+		Interval:   int64(s.Interval / time.Second),
+		Complete:   int64(res.seeders),
+		Incomplete: int64(res.leechers),
+	}
+
+	// Peers are compact unless asked otherwise
+	if query.Get("compact") == "0" {
+		list := make([]peerDict, len(res.peers))
+		for i, p := range res.peers {
+			list[i] = peerDict{IP: p.Addr.Addr().String(), Port: int64(p.Addr.Port())}
+			if query.Get("no_peer_id") != "1" {
+				list[i].PeerID = p.ID
+			}
+		}
+		resp.Peers = list
+
+		return resp, nil
+	}
+
+	resp.Peers = string(compactPeers(res.peers, false))
+	resp.Peers6 = string(compactPeers(res.peers, true))
+
+	return resp, nil
+}
+
+func (s *Server) serveHTTPScrape(r *http.Request) (any, error) {
+	infoHashes := r.URL.Query()["info_hash"]
+	for _, infoHash := range infoHashes {
+		if len(infoHash) != 20 {
+			return nil, fmt.Errorf("invalid info_hash")
+		}
+	}
+
+	files := make(map[string]scrapeFileDict)
+	for infoHash, res := range s.scrape(infoHashes) {
+		files[infoHash] = scrapeFileDict{
+			Complete:   int64(res.Seeders),
+			Downloaded: int64(res.Completed),
+			Incomplete: int64(res.Leechers),
+		}
+	}
+
+	return map[string]any{"files": files}, nil
+}
+
+// announce updates the swarm of the torrent with the announcing peer and
+// returns other peers of the swarm, picked at random.
+func (s *Server) announce(params announceParams) (*announceResult, error) {
+	if s.Allowlist != nil && !s.Allowlist[params.infoHash] {
+		return nil, errNotAllowed
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	sw, ok := s.swarms[params.infoHash]
+	if !ok {
+		sw = &swarm{peers: make(map[string]*swarmPeer)}
+		s.swarms[params.infoHash] = sw
+	}
+
+	sw.expire(now.Add(-s.PeerTTL))
+
+	if params.event == EventStopped {
+		delete(sw.peers, params.peerID)
+	} else {
+		if params.event == EventCompleted {
+			sw.completed++
+		}
+
+		sw.peers[params.peerID] = &swarmPeer{
+			Peer:     peer.Peer{Addr: params.addr, ID: params.peerID},
+			left:     params.left,
+			lastSeen: now,
+		}
+	}
+
+	numWant := params.numWant
+	if numWant <= 0 {
+		numWant = defaultNumWant
+	}
+	numWant = min(numWant, maxNumWant)
+
+	res := &announceResult{}
+
+	// Map iteration order is random enough to pick peers from
+	for id, p := range sw.peers {
+		if p.left == 0 {
+			res.seeders++
+		} else {
+			res.leechers++
+		}
+
+		if id != params.peerID && len(res.peers) < numWant && params.event != EventStopped {
+			res.peers = append(res.peers, p.Peer)
+		}
+	}
+
+	return res, nil
+}
+
+// scrape returns the stats of the swarms of the torrents, or of all
+// swarms if no info hash is given. Unknown torrents are left out.
+func (s *Server) scrape(infoHashes []string) map[string]ScrapeResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(infoHashes) == 0 {
+		for infoHash := range s.swarms {
+			infoHashes = append(infoHashes, infoHash)
+		}
+	}
+
+	cutoff := time.Now().Add(-s.PeerTTL)
+	results := make(map[string]ScrapeResult, len(infoHashes))
+
+	for _, infoHash := range infoHashes {
+		sw, ok := s.swarms[infoHash]
+		if !ok {
+			continue
+		}
+
+		sw.expire(cutoff)
+
+		res := ScrapeResult{Completed: sw.completed}
+		for _, p := range sw.peers {
+			if p.left == 0 {
+				res.Seeders++
+			} else {
+				res.Leechers++
+			}
+		}
+
+		results[infoHash] = res
+	}
+
+	return results
+}
+
+// ExpirePeers drops the peers that stopped announcing,
+// and the swarms left without peers.
+func (s *Server) ExpirePeers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-s.PeerTTL)
+
+	for infoHash, sw := range s.swarms {
+		sw.expire(cutoff)
+
+		if len(sw.peers) == 0 {
+			delete(s.swarms, infoHash)
+		}
+	}
+}
+
+func (s *Server) expireLoop() {
+	ticker := time.NewTicker(max(s.PeerTTL/4, time.Second))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.ExpirePeers()
+	}
+}
+
+// expire drops the peers last seen before the cutoff.
+func (sw *swarm) expire(cutoff time.Time) {
+	for id, p := range sw.peers {
+		if p.lastSeen.Before(cutoff) {
+			delete(sw.peers, id)
+		}
+	}
+}
+
+// compactPeers encodes the IPv4 or IPv6 peers in the compact form,
+// each an IP address followed by a big-endian 2 byte port.
+func compactPeers(peers []peer.Peer, ipv6 bool) []byte {
+	var b []byte
+
+	for _, p := range peers {
+		addr := p.Addr.Addr()
+		if addr.Is6() != ipv6 {
+			continue
+		}
+
+		b = append(b, addr.AsSlice()...)
+		b = binary.BigEndian.AppendUint16(b, p.Addr.Port())
+	}
+
+	return b
+}
+
+// parseEvent parses the event of an HTTP announce.
+func parseEvent(s string) (Event, error) {
+	for _, e := range []Event{EventNone, EventCompleted, EventStarted, EventStopped} {
+		if e.String() == s {
+			return e, nil
+		}
+	}
+
+	return EventNone, fmt.Errorf("invalid event: %q", s)
+}
@@ -0,0 +1,109 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+)
+
+// ErrNoTrackers is returned when announcing a torrent without trackers.
+var ErrNoTrackers = errors.New("no trackers to announce to")
+
+// Tiers holds the trackers of a torrent grouped into tiers,
+// following the multitracker semantics of BEP 12.
+type Tiers struct {
+	tiers [][]string
+	mu    sync.Mutex
+}
+
+// NewTiers creates the tracker tiers from the announce list of
+// a torrent. The trackers within each tier are shuffled once, as
+// required by BEP 12, and the given tiers are left unmodified.
+func NewTiers(tiers [][]string) *Tiers {
+	tt := &Tiers{tiers: make([][]string, 0, len(tiers))}
+
+	for _, tier := range tiers {
+		if len(tier) == 0 {
+			continue
+		}
+
+		shuffled := make([]string, len(tier))
+		copy(shuffled, tier)
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+
+		tt.tiers = append(tt.tiers, shuffled)
+	}
+
+	return tt
+}
+
+// Tiers returns a copy of the tiers in their current order.
+func (tt *Tiers) Tiers() [][]string {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	tiers := make([][]string, len(tt.tiers))
+	for i, tier := range tt.tiers {
+		tiers[i] = append([]string(nil), tier...)
+	}
+
+	return tiers
+}
+
+// each calls fn with the trackers, tier by tier. Within a tier, trackers
+// are tried in order until fn succeeds, and that tracker is moved to the
+// front of its tier so it is tried first next time. A tier whose trackers
+// all fail is skipped in favour of the next one. It fails if fn failed
+// for every tracker, or ErrNoTrackers if there are none.
+func (tt *Tiers) each(fn func(announce string) error) error {
+	var (
+		errs  []error
+		found bool
+	)
+
+	tiers := tt.Tiers()
+	if len(tiers) == 0 {
+		return ErrNoTrackers
+	}
+
+	for tierIdx, tier := range tiers {
+		for _, announce := range tier {
+			if err := fn(announce); err != nil {
+				log.Printf("Tracker %v failed: %v\n", announce, err)
+				errs = append(errs, fmt.Errorf("tracker %v: %w", announce, err))
+				continue
+			}
+
+			tt.promote(tierIdx, announce)
+			found = true
+
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("no tracker responded: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// promote moves the tracker to the front of its tier.
+func (tt *Tiers) promote(tierIdx int, announce string) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	tier := tt.tiers[tierIdx]
+
+	for i, url := range tier {
+		if url == announce {
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = announce
+			return
+		}
+	}
+}
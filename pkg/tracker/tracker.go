@@ -0,0 +1,119 @@
+// Package tracker implements the tracker protocol, which announces this
+// client to the trackers of a torrent and gets the peers of the torrent
+// in return. Server is the tracker side of it.
+package tracker
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/peer"
+)
+
+// Event is the event of an announce, telling the tracker about a change
+// of the state of the download. The values are those of the UDP tracker
+// protocol (BEP 15).
+type Event int
+
+const (
+	// EventNone is sent with regular announces
+	EventNone Event = iota
+	// EventCompleted is sent once, when the download completes
+	EventCompleted
+	// EventStarted is sent with the first announce
+	EventStarted
+	// EventStopped is sent when the client stops downloading or seeding
+	EventStopped
+)
+
+// String returns the event as sent to HTTP trackers, empty for EventNone.
+func (e Event) String() string {
+	switch e {
+	case EventCompleted:
+		return "completed"
+	case EventStarted:
+		return "started"
+	case EventStopped:
+		return "stopped"
+	default:
+		return ""
+	}
+}
+
+// DefaultPort is the port announced to trackers
+// when no other port is given.
+const DefaultPort = 6881
+
+// AnnounceRequest holds the parameters of an announce.
+type AnnounceRequest struct {
+	InfoHash string
+	PeerID   string
+	// Port is the port we accept peer connections on
+	Port uint16
+	// Uploaded, Downloaded and Left are the transfer stats
+	// of the torrent, in bytes
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      Event
+	// NumWant is the number of peers wanted, 0 for the tracker's default
+	NumWant int
+	// TrackerID is the tracker id received from the tracker in a
+	// previous announce, if any
+	TrackerID string
+}
+
+// AnnounceResponse is the response of a tracker to an announce.
+type AnnounceResponse struct {
+	// Interval is the time to wait before the next regular announce
+	Interval time.Duration
+	// MinInterval is the minimum time between announces, 0 if not given
+	MinInterval time.Duration
+	// TrackerID is to be sent back with the next announces, if not empty
+	TrackerID string
+	// Warning is a warning message of the tracker, if any
+	Warning string
+	// Seeders and Leechers are the number of peers with and
+	// without the complete file, -1 if not given
+	Seeders  int
+	Leechers int
+	Peers    []peer.Peer
+}
+
+// ScrapeResult holds the stats of the swarm of a torrent.
+type ScrapeResult struct {
+	// Seeders and Leechers are the number of peers with and
+	// without the complete file
+	Seeders  int
+	Leechers int
+	// Completed is the number of times the download completed
+	Completed int
+}
+
+// FailureError is returned when a tracker refuses an announce,
+// holding the failure reason given by the tracker.
+type FailureError struct {
+	Reason string
+}
+
+func (e *FailureError) Error() string {
+	return "tracker failure: " + e.Reason
+}
+
+// Announce sends the announce request to the tracker at the announce URL.
+func Announce(announce string, req *AnnounceRequest) (*AnnounceResponse, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid announce URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return announceHTTP(u, req)
+	case "udp":
+		return announceUDP(u, req)
+	default:
+		return nil, fmt.Errorf("unsupported tracker protocol: %q", u.Scheme)
+	}
+}
@@ -0,0 +1,302 @@
+package tracker
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// UDP tracker protocol (BEP 15)
+const (
+	// udpProtocolID is the magic connection ID of connect requests
+	udpProtocolID = 0x41727101980
+
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionScrape   = 2
+	udpActionError    = 3
+
+	// udpConnIDLifetime is how long a connection ID is used for. Trackers
+	// accept connection IDs for two minutes, clients use them for one.
+	udpConnIDLifetime = time.Minute
+	// udpTimeout is the time to wait for the first response, doubled
+	// after every retransmission
+	udpTimeout = 15 * time.Second
+	// udpMaxRetransmits bounds the retransmissions of a request. BEP 15
+	// allows up to 8, which would keep a dead tracker busy for hours
+	// instead of moving on to the next tracker of the tier.
+	udpMaxRetransmits = 3
+	// udpMaxScrapeHashes is the number of info hashes
+	// that fit in a scrape request
+	udpMaxScrapeHashes = 74
+	// udpMaxPacketSize bounds the size of a response read
+	udpMaxPacketSize = 4096
+)
+
+// errUDPTimeout is returned when a UDP tracker doesn't respond in time.
+var errUDPTimeout = errors.New("timeout waiting for UDP tracker response")
+
+// udpClient sends requests to UDP trackers, keeping the
+// connection IDs of the trackers for their lifetime.
+type udpClient struct {
+	mu      sync.Mutex
+	connIDs map[string]udpConnID
+}
+
+type udpConnID struct {
+	id      uint64
+	expires time.Time
+}
+
+var defaultUDPClient = &udpClient{connIDs: make(map[string]udpConnID)}
+
+// udpKey identifies this client to UDP trackers across announces,
+// should its IP address change.
+var udpKey = func() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}()
+
+// announceUDP sends the announce request to a UDP tracker.
+func announceUDP(u *url.URL, req *AnnounceRequest) (*AnnounceResponse, error) {
+	return defaultUDPClient.announce(u, req)
+}
+
+// scrapeUDP sends a scrape request for the info hashes to a UDP tracker.
+func scrapeUDP(u *url.URL, infoHashes []string) (map[string]ScrapeResult, error) {
+	return defaultUDPClient.scrape(u, infoHashes)
+}
+
+func (c *udpClient) announce(u *url.URL, req *AnnounceRequest) (*AnnounceResponse, error) {
+	conn, err := dialUDP(u)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	numWant := int32(-1)
+	if req.NumWant > 0 {
+		numWant = int32(req.NumWant)
+	}
+
+	body := make([]byte, 0, 82)
+	body = append(body, req.InfoHash...)
+	body = append(body, req.PeerID...)
+	body = binary.BigEndian.AppendUint64(body, uint64(req.Downloaded))
+	body = binary.BigEndian.AppendUint64(body, uint64(req.Left))
+	body = binary.BigEndian.AppendUint64(body, uint64(req.Uploaded))
+	body = binary.BigEndian.AppendUint32(body, uint32(req.Event))
+	body = binary.BigEndian.AppendUint32(body, 0) // IP address, the sender's
+	body = binary.BigEndian.AppendUint32(body, udpKey)
+	body = binary.BigEndian.AppendUint32(body, uint32(numWant))
+	body = binary.BigEndian.AppendUint16(body, req.Port)
+
+	log.Printf("Requesting tracker: %v\n", u)
+
+	resp, err := c.exchange(conn, u.Host, udpActionAnnounce, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp) < 12 {
+		return nil, fmt.Errorf("invalid tracker response: announce response too short")
+	}
+
+	// Peers are IPv6 addresses when announcing over IPv6
+	ipLen := net.IPv4len
+	if addr, ok := conn.RemoteAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		ipLen = net.IPv6len
+	}
+
+	peers, err := parseCompactPeers(string(resp[12:]), ipLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AnnounceResponse{
+		Interval: time.Duration(binary.BigEndian.Uint32(resp[0:])) * time.Second,
+		Leechers: int(binary.BigEndian.Uint32(resp[4:])),
+		Seeders:  int(binary.BigEndian.Uint32(resp[8:])),
+		Peers:    peers,
+	}, nil
+}
+
+func (c *udpClient) scrape(u *url.URL, infoHashes []string) (map[string]ScrapeResult, error) {
+	conn, err := dialUDP(u)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	results := make(map[string]ScrapeResult, len(infoHashes))
+
+	for len(infoHashes) > 0 {
+		batch := infoHashes[:min(len(infoHashes), udpMaxScrapeHashes)]
+		infoHashes = infoHashes[len(batch):]
+
+		body := make([]byte, 0, len(batch)*20)
+		for _, infoHash := range batch {
+			body = append(body, infoHash...)
+		}
+
+		resp, err := c.exchange(conn, u.Host, udpActionScrape, body)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(resp) < len(batch)*12 {
+			return nil, fmt.Errorf("invalid tracker response: scrape response too short")
+		}
+
+		for i, infoHash := range batch {
+			stats := resp[i*12:]
+			results[infoHash] = ScrapeResult{
+				Seeders:   int(binary.BigEndian.Uint32(stats[0:])),
+				Completed: int(binary.BigEndian.Uint32(stats[4:])),
+				Leechers:  int(binary.BigEndian.Uint32(stats[8:])),
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func dialUDP(u *url.URL) (net.Conn, error) {
+	if u.Port() == "" {
+		return nil, fmt.Errorf("invalid announce URL: missing port in %v", u)
+	}
+
+	conn, err := net.Dial("udp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to tracker: %w", err)
+	}
+
+	return conn, nil
+}
+
+// exchange sends the request of the action to the tracker, connecting
+// first unless there's a connection ID of the tracker at hand, and
+// returns the body of the response. Requests that get no response are
+// retransmitted, waiting twice as long every time.
+func (c *udpClient) exchange(conn net.Conn, host string, action uint32, body []byte) ([]byte, error) {
+	for n := 0; ; n++ {
+		timeout := udpTimeout << n
+
+		resp, err := c.tryExchange(conn, host, action, body, timeout)
+		if !errors.Is(err, errUDPTimeout) || n == udpMaxRetransmits {
+			return resp, err
+		}
+	}
+}
+
+func (c *udpClient) tryExchange(conn net.Conn, host string, action uint32, body []byte, timeout time.Duration) ([]byte, error) {
+	connID, ok := c.connID(host)
+	if !ok {
+		resp, err := udpRoundTrip(conn, udpProtocolID, udpActionConnect, nil, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(resp) < 8 {
+			return nil, fmt.Errorf("invalid tracker response: connect response too short")
+		}
+
+		connID = binary.BigEndian.Uint64(resp)
+		c.setConnID(host, connID)
+	}
+
+	resp, err := udpRoundTrip(conn, connID, action, body, timeout)
+
+	var failure *FailureError
+	if errors.As(err, &failure) {
+		// The connection ID may have expired on the tracker side
+		c.dropConnID(host)
+	}
+
+	return resp, err
+}
+
+// udpRoundTrip sends a request and waits for the response with the same
+// transaction ID, returning the body of the response.
+func udpRoundTrip(conn net.Conn, connID uint64, action uint32, body []byte, timeout time.Duration) ([]byte, error) {
+	var tid [4]byte
+	if _, err := rand.Read(tid[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate transaction ID: %w", err)
+	}
+
+	packet := make([]byte, 0, 16+len(body))
+	packet = binary.BigEndian.AppendUint64(packet, connID)
+	packet = binary.BigEndian.AppendUint32(packet, action)
+	packet = append(packet, tid[:]...)
+	packet = append(packet, body...)
+
+	if _, err := conn.Write(packet); err != nil {
+		return nil, fmt.Errorf("failed to send tracker request: %w", err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, udpMaxPacketSize)
+
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, errUDPTimeout
+			}
+			return nil, fmt.Errorf("failed to read tracker response: %w", err)
+		}
+
+		// Skip stray responses to earlier requests
+		if n < 8 || string(buf[4:8]) != string(tid[:]) {
+			continue
+		}
+
+		resp := append([]byte(nil), buf[8:n]...)
+
+		switch respAction := binary.BigEndian.Uint32(buf); respAction {
+		case action:
+			return resp, nil
+		case udpActionError:
+			return nil, &FailureError{Reason: string(resp)}
+		default:
+			return nil, fmt.Errorf("invalid tracker response: unexpected action %d", respAction)
+		}
+	}
+}
+
+func (c *udpClient) connID(host string) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	connID, ok := c.connIDs[host]
+	if !ok || time.Now().After(connID.expires) {
+		return 0, false
+	}
+
+	return connID.id, true
+}
+
+func (c *udpClient) setConnID(host string, id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.connIDs[host] = udpConnID{id, time.Now().Add(udpConnIDLifetime)}
+}
+
+func (c *udpClient) dropConnID(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.connIDs, host)
+}
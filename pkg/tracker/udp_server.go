@@ -0,0 +1,191 @@
+package tracker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// udpAnnounceSize is the size of an announce request,
+// header included.
+const udpAnnounceSize = 98
+
+// ServeUDP serves announces and scrapes of UDP clients (BEP 15) on the
+// connection, until reading from it fails.
+func (s *Server) ServeUDP(conn net.PacketConn) error {
+	buf := make([]byte, udpMaxPacketSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to read UDP request: %w", err)
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || n < 16 {
+			continue
+		}
+
+		resp := s.handleUDP(buf[:n], udpAddr.AddrPort())
+		if resp == nil {
+			continue
+		}
+
+		if _, err := conn.WriteTo(resp, addr); err != nil {
+			log.Printf("Failed to send UDP response to %v: %v\n", addr, err)
+		}
+	}
+}
+
+// handleUDP returns the response to the request from the address,
+// or nil if the request is to be dropped.
+func (s *Server) handleUDP(req []byte, from netip.AddrPort) []byte {
+	from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+
+	connID := binary.BigEndian.Uint64(req)
+	action := binary.BigEndian.Uint32(req[8:])
+	tid := req[12:16]
+	body := req[16:]
+
+	if action == udpActionConnect {
+		if connID != udpProtocolID {
+			return nil
+		}
+
+		return udpPacket(udpActionConnect, tid, binary.BigEndian.AppendUint64(nil, s.udpConnID(from.Addr(), time.Now())))
+	}
+
+	if !s.validUDPConnID(connID, from.Addr()) {
+		return udpPacket(udpActionError, tid, []byte("invalid connection ID"))
+	}
+
+	var (
+		resp []byte
+		err  error
+	)
+
+	switch action {
+	case udpActionAnnounce:
+		resp, err = s.handleUDPAnnounce(body, from)
+	case udpActionScrape:
+		resp, err = s.handleUDPScrape(body)
+	default:
+		err = fmt.Errorf("unknown action %d", action)
+	}
+
+	if err != nil {
+		return udpPacket(udpActionError, tid, []byte(err.Error()))
+	}
+
+	return udpPacket(action, tid, resp)
+}
+
+func (s *Server) handleUDPAnnounce(body []byte, from netip.AddrPort) ([]byte, error) {
+	if len(body) < udpAnnounceSize-16 {
+		return nil, fmt.Errorf("announce request too short")
+	}
+
+	event := Event(binary.BigEndian.Uint32(body[64:]))
+	if event > EventStopped {
+		return nil, fmt.Errorf("invalid event: %d", event)
+	}
+
+	left := int64(binary.BigEndian.Uint64(body[48:]))
+	if left < 0 {
+		return nil, fmt.Errorf("invalid left")
+	}
+
+	port := binary.BigEndian.Uint16(body[80:])
+	if port == 0 {
+		return nil, fmt.Errorf("invalid port")
+	}
+
+	// The IP address field is ignored,
+	// peers are announced at the sender's address
+	res, err := s.announce(announceParams{
+		infoHash: string(body[0:20]),
+		peerID:   string(body[20:40]),
+		addr:     netip.AddrPortFrom(from.Addr(), port),
+		left:     left,
+		event:    event,
+		numWant:  int(int32(binary.BigEndian.Uint32(body[76:]))),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := binary.BigEndian.AppendUint32(nil, uint32(s.Interval/time.Second))
+	resp = binary.BigEndian.AppendUint32(resp, uint32(res.leechers))
+	resp = binary.BigEndian.AppendUint32(resp, uint32(res.seeders))
+
+	// Clients expect peers of the address family they announced over
+	resp = append(resp, compactPeers(res.peers, from.Addr().Is6())...)
+
+	return resp, nil
+}
+
+func (s *Server) handleUDPScrape(body []byte) ([]byte, error) {
+	if len(body) == 0 || len(body)%20 != 0 || len(body)/20 > udpMaxScrapeHashes {
+		return nil, fmt.Errorf("invalid scrape request")
+	}
+
+	infoHashes := make([]string, 0, len(body)/20)
+	for i := 0; i < len(body); i += 20 {
+		infoHashes = append(infoHashes, string(body[i:i+20]))
+	}
+
+	results := s.scrape(infoHashes)
+
+	// Unknown torrents are reported with zero stats,
+	// in the order of the request
+	resp := make([]byte, 0, len(infoHashes)*12)
+	for _, infoHash := range infoHashes {
+		res := results[infoHash]
+		resp = binary.BigEndian.AppendUint32(resp, uint32(res.Seeders))
+		resp = binary.BigEndian.AppendUint32(resp, uint32(res.Completed))
+		resp = binary.BigEndian.AppendUint32(resp, uint32(res.Leechers))
+	}
+
+	return resp, nil
+}
+
+// udpConnID returns the connection ID of the IP address at the time. IDs
+// are derived from the address and the current minute rather than kept,
+// so that spoofed connect requests cost nothing. The port is left out as
+// clients may announce from a new socket each time.
+func (s *Server) udpConnID(addr netip.Addr, t time.Time) uint64 {
+	mac := hmac.New(sha256.New, s.secret[:])
+	b, _ := addr.MarshalBinary()
+	mac.Write(b)
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(t.Unix()/int64(udpConnIDLifetime/time.Second))))
+
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// validUDPConnID checks that the connection ID was given to the
+// address in the current or the previous minute, so that IDs are
+// accepted for at least one minute and at most two.
+func (s *Server) validUDPConnID(connID uint64, addr netip.Addr) bool {
+	now := time.Now()
+
+	return connID == s.udpConnID(addr, now) || connID == s.udpConnID(addr, now.Add(-udpConnIDLifetime))
+}
+
+// udpPacket builds a response packet of the action to the transaction.
+func udpPacket(action uint32, tid []byte, body []byte) []byte {
+	packet := make([]byte, 0, 8+len(body))
+	packet = binary.BigEndian.AppendUint32(packet, action)
+	packet = append(packet, tid...)
+	packet = append(packet, body...)
+
+	return packet
+}
@@ -0,0 +1,170 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/pkg/peer"
+)
+
+const (
+	testInfoHash      = "\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13\x14"
+	testOtherInfoHash = "\xff\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13\x14"
+)
+
+// serveTestUDP serves the tracker on a loopback UDP socket
+// and returns the announce URL of the tracker.
+func serveTestUDP(t *testing.T, s *Server) *url.URL {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go s.ServeUDP(conn)
+
+	return &url.URL{Scheme: "udp", Host: conn.LocalAddr().String(), Path: "/announce"}
+}
+
+func TestUDPLoopback(t *testing.T) {
+	u := serveTestUDP(t, NewServer())
+	c := &udpClient{connIDs: make(map[string]udpConnID)}
+
+	seeder := &AnnounceRequest{InfoHash: testInfoHash, PeerID: strings.Repeat("s", 20), Port: 6881, Event: EventStarted}
+	if _, err := c.announce(u, seeder); err != nil {
+		t.Fatalf("announce of the seeder: %v", err)
+	}
+
+	leecher := &AnnounceRequest{InfoHash: testInfoHash, PeerID: strings.Repeat("l", 20), Port: 6882, Left: 100, Event: EventStarted}
+	res, err := c.announce(u, leecher)
+	if err != nil {
+		t.Fatalf("announce of the leecher: %v", err)
+	}
+
+	want := &AnnounceResponse{
+		Interval: DefaultServerInterval,
+		Seeders:  1,
+		Leechers: 1,
+		Peers:    []peer.Peer{{Addr: netip.MustParseAddrPort("127.0.0.1:6881")}},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("announce = %+v, want %+v", res, want)
+	}
+
+	results, err := c.scrape(u, []string{testInfoHash, testOtherInfoHash})
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+
+	wantResults := map[string]ScrapeResult{
+		testInfoHash:      {Seeders: 1, Leechers: 1},
+		testOtherInfoHash: {},
+	}
+	if !reflect.DeepEqual(results, wantResults) {
+		t.Errorf("scrape = %+v, want %+v", results, wantResults)
+	}
+
+	// Stopped peers leave the swarm
+	seeder.Event = EventStopped
+	if _, err := c.announce(u, seeder); err != nil {
+		t.Fatalf("announce of the stopped seeder: %v", err)
+	}
+
+	results, err = c.scrape(u, []string{testInfoHash})
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+	if got, want := results[testInfoHash], (ScrapeResult{Leechers: 1}); got != want {
+		t.Errorf("scrape after stop = %+v, want %+v", got, want)
+	}
+}
+
+func TestUDPLoopbackNotAllowed(t *testing.T) {
+	s := NewServer()
+	s.Allowlist = map[string]bool{testOtherInfoHash: true}
+
+	u := serveTestUDP(t, s)
+	c := &udpClient{connIDs: make(map[string]udpConnID)}
+
+	_, err := c.announce(u, &AnnounceRequest{InfoHash: testInfoHash, PeerID: strings.Repeat("p", 20), Port: 6881})
+	if err == nil || !strings.Contains(err.Error(), errNotAllowed.Error()) {
+		t.Errorf("announce error = %v, want %q", err, errNotAllowed)
+	}
+}
+
+// udpRequest builds a request packet of the action.
+func udpRequest(connID uint64, action uint32, body []byte) []byte {
+	req := binary.BigEndian.AppendUint64(nil, connID)
+	req = binary.BigEndian.AppendUint32(req, action)
+	req = append(req, "tid!"...)
+
+	return append(req, body...)
+}
+
+func TestUDPConnIDs(t *testing.T) {
+	s := NewServer()
+
+	from := netip.MustParseAddrPort("127.0.0.1:40000")
+
+	// Connect requests must carry the protocol ID
+	if resp := s.handleUDP(udpRequest(0, udpActionConnect, nil), from); resp != nil {
+		t.Errorf("connect without the protocol ID = %x, want it dropped", resp)
+	}
+
+	resp := s.handleUDP(udpRequest(udpProtocolID, udpActionConnect, nil), from)
+	if len(resp) != 16 || binary.BigEndian.Uint32(resp) != udpActionConnect || string(resp[4:8]) != "tid!" {
+		t.Fatalf("connect response = %x", resp)
+	}
+	connID := binary.BigEndian.Uint64(resp[8:])
+
+	scrape := []byte(testInfoHash)
+
+	tests := []struct {
+		name   string
+		connID uint64
+		from   string
+		ok     bool
+	}{
+		{"same address", connID, "127.0.0.1:40000", true},
+		{"same IP from another port", connID, "127.0.0.1:40001", true},
+		{"IPv4-mapped address", connID, "[::ffff:127.0.0.1]:40000", true},
+		{"another IP", connID, "127.0.0.2:40000", false},
+		{"another family", connID, "[::1]:40000", false},
+		{"wrong ID", connID + 1, "127.0.0.1:40000", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := s.handleUDP(udpRequest(tt.connID, udpActionScrape, scrape), netip.MustParseAddrPort(tt.from))
+
+			wantAction := uint32(udpActionScrape)
+			if !tt.ok {
+				wantAction = udpActionError
+			}
+			if got := binary.BigEndian.Uint32(resp); got != wantAction {
+				t.Errorf("response action = %d, want %d (%q)", got, wantAction, resp[8:])
+			}
+		})
+	}
+}
+
+func TestUDPConnIDLifetime(t *testing.T) {
+	s := NewServer()
+	addr := netip.MustParseAddr("127.0.0.1")
+	now := time.Now()
+
+	if !s.validUDPConnID(s.udpConnID(addr, now.Add(-udpConnIDLifetime)), addr) {
+		t.Error("ID of the previous minute rejected")
+	}
+	if s.validUDPConnID(s.udpConnID(addr, now.Add(-2*udpConnIDLifetime)), addr) {
+		t.Error("ID of two minutes ago accepted")
+	}
+}